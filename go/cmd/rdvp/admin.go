@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// adminHandler serves the operator commands. It has no authentication and
+// must only be exposed on a trusted interface (ie. loopback).
+type adminHandler struct {
+	logger *zap.Logger
+	host   libp2p_host.Host
+	svc    *service
+	bw     *periodBandwidthCounter
+
+	mux *http.ServeMux
+}
+
+func newAdminHandler(logger *zap.Logger, host libp2p_host.Host, svc *service, bw *periodBandwidthCounter) *adminHandler {
+	a := &adminHandler{
+		logger: logger,
+		host:   host,
+		svc:    svc,
+		bw:     bw,
+		mux:    http.NewServeMux(),
+	}
+
+	a.mux.HandleFunc("/evict", a.handleEvict)
+	a.mux.HandleFunc("/bandwidth", a.handleBandwidth)
+	a.mux.HandleFunc("/debug/peerstore", a.handlePeerstore)
+	a.mux.HandleFunc("/maintenance", a.handleMaintenance)
+	a.mux.HandleFunc("/limits", a.handleLimits)
+
+	return a
+}
+
+func (a *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+type evictResult struct {
+	Peer         string `json:"peer"`
+	Removed      int    `json:"removed"`
+	Disconnected bool   `json:"disconnected"`
+}
+
+// handleEvict removes all the registrations of a peer.
+//
+//	POST /evict?peer=<peer id>[&disconnect=true]
+func (a *adminHandler) handleEvict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := libp2p_peer.Decode(r.URL.Query().Get("peer"))
+	if err != nil {
+		http.Error(w, "invalid peer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var disconnect bool
+	if v := r.URL.Query().Get("disconnect"); v != "" {
+		if disconnect, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid disconnect: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	removed, err := a.svc.evict(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := evictResult{Peer: p.String(), Removed: removed}
+	if disconnect {
+		if err := a.host.Network().ClosePeer(p); err != nil {
+			a.logger.Warn("unable to disconnect evicted peer", zap.Stringer("peer", p), zap.Error(err))
+		} else {
+			res.Disconnected = true
+		}
+	}
+
+	a.logger.Info("peer evicted", zap.Stringer("peer", p), zap.Int("registrations", removed), zap.Bool("disconnected", res.Disconnected))
+
+	writeJSON(w, res)
+}
+
+// handleBandwidth returns the traffic of the current accounting period, by
+// protocol. With reset, the period is closed and a new one starts, without
+// any byte lost or counted twice between the two.
+//
+//	GET  /bandwidth
+//	POST /bandwidth?reset=true
+func (a *adminHandler) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	var reset bool
+	if v := r.URL.Query().Get("reset"); v != "" {
+		var err error
+		if reset, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid reset: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if reset && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, a.bw.snapshot(reset))
+}
+
+const (
+	peerstoreDefaultLimit = 100
+	peerstoreMaxLimit     = 1000
+)
+
+type peerstoreEntry struct {
+	ID        string   `json:"id"`
+	Connected bool     `json:"connected"`
+	Addrs     []string `json:"addrs"`
+	Protocols []string `json:"protocols"`
+	Latency   string   `json:"latency,omitempty"`
+}
+
+type peerstorePage struct {
+	Total  int              `json:"total"`
+	Offset int              `json:"offset"`
+	Peers  []peerstoreEntry `json:"peers"`
+}
+
+// handlePeerstore dumps the peerstore, ordered by peer ID.
+//
+//	GET /debug/peerstore[?offset=0&limit=100]
+func (a *adminHandler) handlePeerstore(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, peerstoreDefaultLimit
+	for name, dst := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+	if limit == 0 || limit > peerstoreMaxLimit {
+		limit = peerstoreMaxLimit
+	}
+
+	ps := a.host.Peerstore()
+	peers := ps.Peers()
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	page := peerstorePage{Total: len(peers), Offset: offset, Peers: []peerstoreEntry{}}
+	if offset < len(peers) {
+		peers = peers[offset:]
+		if len(peers) > limit {
+			peers = peers[:limit]
+		}
+
+		for _, p := range peers {
+			entry := peerstoreEntry{
+				ID:        p.String(),
+				Connected: a.host.Network().Connectedness(p) == libp2p_network.Connected,
+				Addrs:     []string{},
+				Protocols: []string{},
+			}
+
+			for _, addr := range ps.Addrs(p) {
+				entry.Addrs = append(entry.Addrs, addr.String())
+			}
+
+			if protos, err := ps.GetProtocols(p); err == nil {
+				for _, proto := range protos {
+					entry.Protocols = append(entry.Protocols, string(proto))
+				}
+			}
+
+			if latency := ps.LatencyEWMA(p); latency > 0 {
+				entry.Latency = latency.String()
+			}
+
+			page.Peers = append(page.Peers, entry)
+		}
+	}
+
+	writeJSON(w, page)
+}
+
+type maintenanceState struct {
+	Message string `json:"message"`
+}
+
+// maxMaintenanceMessageSize caps the maintenance message, it is sent in
+// every response to the clients.
+const maxMaintenanceMessageSize = 1 << 10
+
+// handleMaintenance reads or sets the maintenance message sent to the
+// clients, an empty message ends the maintenance. The message is read from
+// the query or from a form body.
+//
+//	GET  /maintenance
+//	POST /maintenance?message=<message>
+func (a *adminHandler) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// the url encoding takes up to 3 bytes per byte of the message
+		r.Body = http.MaxBytesReader(w, r.Body, int64(3*maxMaintenanceMessageSize+len("message=")))
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		msg := r.Form.Get("message")
+		if len(msg) > maxMaintenanceMessageSize {
+			http.Error(w, "invalid message: too long", http.StatusBadRequest)
+			return
+		}
+
+		a.svc.setMaintenanceMessage(msg)
+		a.logger.Info("maintenance message updated", zap.String("message", msg))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, maintenanceState{Message: a.svc.maintenanceMessage()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type limitsState struct {
+	// MaxTTL is the maximum ttl of the new registrations, in seconds.
+	MaxTTL int64 `json:"max_ttl"`
+	// DiscoverRate is the discover queries and subscriptions per minute of
+	// a peer, unset if -discover-rate is disabled.
+	DiscoverRate *float64 `json:"discover_rate,omitempty"`
+	// NewNamespaceRate is the registrations on new namespaces per minute of
+	// a peer, unset if -new-namespace-rate is disabled.
+	NewNamespaceRate *float64 `json:"new_namespace_rate,omitempty"`
+}
+
+// handleLimits reads or adjusts the limits of the running service, the
+// new values apply to the following requests. The rate limits can only be
+// adjusted if enabled at startup.
+//
+//	GET /limits
+//	POST /limits?max_ttl=<seconds>&discover_rate=<per minute>&new_namespace_rate=<per minute>
+func (a *adminHandler) handleLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if v := r.URL.Query().Get("max_ttl"); v != "" {
+			ttl, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid max_ttl: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			prev, err := a.svc.setMaxTTL(ttl)
+			if err != nil {
+				http.Error(w, "invalid max_ttl: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			a.logger.Info("limit updated", zap.String("limit", "max_ttl"), zap.Int64("previous", prev), zap.Int64("value", ttl))
+		}
+
+		for _, l := range []struct {
+			name    string
+			limiter *peerRateLimiter
+		}{
+			{"discover_rate", a.svc.opts.DiscoverLimiter},
+			{"new_namespace_rate", a.svc.opts.NewNamespaceLimiter},
+		} {
+			name, limiter := l.name, l.limiter
+			v := r.URL.Query().Get(name)
+			if v == "" {
+				continue
+			}
+
+			if limiter == nil {
+				http.Error(w, "invalid "+name+": disabled at startup", http.StatusBadRequest)
+				return
+			}
+
+			rate, err := strconv.ParseFloat(v, 64)
+			if err != nil || !(rate > 0) || math.IsInf(rate, 0) {
+				http.Error(w, "invalid "+name+": must be a positive number", http.StatusBadRequest)
+				return
+			}
+
+			prev := limiter.setRate(rate)
+			a.logger.Info("limit updated", zap.String("limit", name), zap.Float64("previous", prev), zap.Float64("value", rate))
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := limitsState{MaxTTL: a.svc.maxTTL()}
+	if limiter := a.svc.opts.DiscoverLimiter; limiter != nil {
+		rate := limiter.perMinute()
+		state.DiscoverRate = &rate
+	}
+	if limiter := a.svc.opts.NewNamespaceLimiter; limiter != nil {
+		rate := limiter.perMinute()
+		state.NewNamespaceRate = &rate
+	}
+	writeJSON(w, state)
+}
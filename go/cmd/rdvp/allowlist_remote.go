@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	remoteAllowlistTimeout = 10 * time.Second
+	remoteAllowlistMaxSize = 1 << 20
+)
+
+// namespaceFilter restricts the namespaces served, see namespaceAllowlist
+// and remoteAllowlist.
+type namespaceFilter interface {
+	Allowed(ns string) bool
+}
+
+// isRemoteAllowlist reports whether the -namespace-allowlist value is the
+// URL of a remote allowlist rather than the patterns themselves.
+func isRemoteAllowlist(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// remoteAllowlist is a namespace allowlist fetched from a URL and
+// refreshed periodically. The document has one or more comma separated
+// patterns per line, `#` starts a comment. A failed refresh keeps the last
+// list fetched.
+type remoteAllowlist struct {
+	logger  *zap.Logger
+	metrics *rdvpMetrics
+	url     string
+	client  *http.Client
+
+	current atomic.Pointer[namespaceAllowlist]
+}
+
+// newRemoteAllowlist fetches the allowlist at url, it fails if the first
+// fetch does.
+func newRemoteAllowlist(ctx context.Context, logger *zap.Logger, metrics *rdvpMetrics, url string) (*remoteAllowlist, error) {
+	l := &remoteAllowlist{
+		logger:  logger,
+		metrics: metrics,
+		url:     url,
+		client:  &http.Client{Timeout: remoteAllowlistTimeout},
+	}
+
+	if err := l.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("unable to fetch the namespace allowlist: %w", err)
+	}
+	return l, nil
+}
+
+// Allowed implements namespaceFilter.
+func (l *remoteAllowlist) Allowed(ns string) bool {
+	return l.current.Load().Allowed(ns)
+}
+
+// run refreshes the allowlist every interval until ctx is done.
+func (l *remoteAllowlist) run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if err := l.refresh(ctx); err != nil && ctx.Err() == nil {
+			l.logger.Warn("unable to refresh the namespace allowlist, keeping the last one", zap.String("url", stripUserinfo(l.url)), zap.Error(err))
+		}
+	}
+}
+
+// refresh fetches the allowlist, the allowlist dependency is up as long as
+// the fetches succeed.
+func (l *remoteAllowlist) refresh(ctx context.Context) error {
+	err := l.fetch(ctx)
+	if err != nil {
+		l.metrics.dependencyUp.WithLabelValues("allowlist").Set(0)
+		return err
+	}
+
+	l.metrics.dependencyUp.WithLabelValues("allowlist").Set(1)
+	l.metrics.allowlistLastRefresh.SetToCurrentTime()
+	return nil
+}
+
+func (l *remoteAllowlist) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
+	allowlist, err := parseRemoteAllowlist(io.LimitReader(res.Body, remoteAllowlistMaxSize))
+	if err != nil {
+		return err
+	}
+
+	if prev := l.current.Swap(&allowlist); prev == nil || strings.Join(*prev, ",") != strings.Join(allowlist, ",") {
+		l.logger.Info("namespace allowlist updated", zap.Strings("patterns", allowlist))
+	}
+	return nil
+}
+
+func parseRemoteAllowlist(r io.Reader) (namespaceAllowlist, error) {
+	var patterns []string
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		for _, pattern := range strings.Split(line, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// an empty allowlist allows everything, never the intent of an empty
+	// (or truncated) document
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("empty allowlist")
+	}
+
+	return parseNamespaceAllowlist(strings.Join(patterns, ","))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRemoteAllowlist(t *testing.T) {
+	var doc atomic.Pointer[string]
+	setDoc := func(s string) { doc.Store(&s) }
+	setDoc("# shared namespaces\nberty/*, exact\n")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := *doc.Load(); d != "" {
+			_, _ = w.Write([]byte(d))
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	metrics := newRdvpMetrics()
+
+	l, err := newRemoteAllowlist(ctx, zap.NewNop(), metrics, srv.URL)
+	require.NoError(t, err)
+	assert.True(t, l.Allowed("berty/foo"))
+	assert.True(t, l.Allowed("exact"))
+	assert.False(t, l.Allowed("other"))
+	assert.NotZero(t, testutil.ToFloat64(metrics.allowlistLastRefresh))
+
+	setDoc("other")
+	require.NoError(t, l.refresh(ctx))
+	assert.True(t, l.Allowed("other"))
+	assert.False(t, l.Allowed("exact"))
+
+	// failures keep the last list
+	setDoc("")
+	assert.Error(t, l.refresh(ctx))
+	assert.True(t, l.Allowed("other"))
+	assert.False(t, l.Allowed("exact"))
+
+	setDoc("# nothing\n")
+	assert.Error(t, l.refresh(ctx))
+	assert.False(t, l.Allowed("exact"))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/config"
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.uber.org/zap"
+)
+
+// dnsAddrsFactory wraps base, replacing the IP of the announced addresses
+// by the DNS name, loopback addresses are dropped.
+func dnsAddrsFactory(name string, base config.AddrsFactory) config.AddrsFactory {
+	return func(ms []ma.Multiaddr) []ma.Multiaddr {
+		var (
+			out  []ma.Multiaddr
+			seen = make(map[string]bool)
+		)
+
+		for _, m := range base(ms) {
+			if manet.IsIPLoopback(m) {
+				continue
+			}
+
+			dnsAddr, ok := toDNSAddr(name, m)
+			if !ok {
+				// not an ip address, announce it as is
+				dnsAddr = m
+			}
+
+			if key := string(dnsAddr.Bytes()); !seen[key] {
+				seen[key] = true
+				out = append(out, dnsAddr)
+			}
+		}
+
+		return out
+	}
+}
+
+// toDNSAddr replaces the leading ip4/ip6 component of m by dns4/dns6 name.
+func toDNSAddr(name string, m ma.Multiaddr) (ma.Multiaddr, bool) {
+	first, rest := ma.SplitFirst(m)
+	if first == nil {
+		return nil, false
+	}
+
+	var proto string
+	switch first.Protocol().Code {
+	case ma.P_IP4:
+		proto = "dns4"
+	case ma.P_IP6:
+		proto = "dns6"
+	default:
+		return nil, false
+	}
+
+	dns, err := ma.NewComponent(proto, name)
+	if err != nil {
+		return nil, false
+	}
+
+	if rest == nil {
+		return dns, true
+	}
+	return dns.Encapsulate(rest), true
+}
+
+// preferTransportAddrsFactory wraps base, moving the addresses using the
+// transport first, clients usually dial in the announced order. The
+// transport is a multiaddr protocol name, `quic` also matches `quic-v1`.
+func preferTransportAddrsFactory(transport string, base config.AddrsFactory) (config.AddrsFactory, error) {
+	if ma.ProtocolWithName(transport).Code == 0 {
+		return nil, fmt.Errorf("unknown transport `%s`", transport)
+	}
+
+	uses := func(m ma.Multiaddr) bool {
+		for _, p := range m.Protocols() {
+			if p.Name == transport || strings.HasPrefix(p.Name, transport+"-") {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(ms []ma.Multiaddr) []ma.Multiaddr {
+		out := append([]ma.Multiaddr(nil), base(ms)...)
+		sort.SliceStable(out, func(i, j int) bool { return uses(out[i]) && !uses(out[j]) })
+		return out
+	}, nil
+}
+
+// checkAnnounceDNS warns if name doesn't resolve, or doesn't resolve to an
+// address the host is listening on (expected behind a NAT or a load
+// balancer).
+func checkAnnounceDNS(ctx context.Context, logger *zap.Logger, host libp2p_host.Host, name string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		logger.Warn("announced dns name doesn't resolve", zap.String("name", name), zap.Error(err))
+		return
+	}
+
+	laddrs, err := host.Network().InterfaceListenAddresses()
+	if err != nil {
+		logger.Warn("unable to get listen addrs", zap.Error(err))
+		return
+	}
+
+	for _, laddr := range laddrs {
+		lip, err := manet.ToIP(laddr)
+		if err != nil {
+			continue
+		}
+
+		for _, ip := range ips {
+			if ip.IP.Equal(lip) {
+				return
+			}
+		}
+	}
+
+	logger.Warn("announced dns name doesn't resolve to a bound address", zap.String("name", name), zap.Any("resolved", ips))
+}
+
+// announceCheckTimeout bounds the dial of each announced address by
+// checkAnnounceDials.
+const announceCheckTimeout = 10 * time.Second
+
+// checkAnnounceDials dials each address announced by host from a
+// short-lived host, and warns about the ones that cannot be dialed within
+// timeout. It reports the number of failed addresses.
+func checkAnnounceDials(ctx context.Context, logger *zap.Logger, host libp2p_host.Host, timeout time.Duration) (int, error) {
+	probe, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		return 0, err
+	}
+	defer probe.Close()
+
+	// one address at a time, the dials to the same peer are merged
+	failed := 0
+	for _, addr := range host.Addrs() {
+		probe.Peerstore().ClearAddrs(host.ID())
+
+		dctx, cancel := context.WithTimeout(ctx, timeout)
+		err := probe.Connect(dctx, libp2p_peer.AddrInfo{ID: host.ID(), Addrs: []ma.Multiaddr{addr}})
+		cancel()
+
+		if ctx.Err() != nil {
+			return failed, ctx.Err()
+		}
+
+		if err != nil {
+			logger.Warn("announced address is not dialable", zap.Stringer("addr", addr), zap.Error(err))
+			failed++
+			continue
+		}
+
+		_ = probe.Network().ClosePeer(host.ID())
+		logger.Debug("announced address is dialable", zap.Stringer("addr", addr))
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDNSAddrsFactory(t *testing.T) {
+	identity := func(ms []ma.Multiaddr) []ma.Multiaddr { return ms }
+	factory := dnsAddrsFactory("rdvp.example.com", identity)
+
+	in := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/4040"),
+		ma.StringCast("/ip4/192.168.1.2/tcp/4040"),
+		ma.StringCast("/ip4/1.2.3.4/tcp/4040"),
+		ma.StringCast("/ip4/1.2.3.4/udp/4141/quic"),
+		ma.StringCast("/ip6/2001:db8::1/tcp/4040"),
+		ma.StringCast("/dns4/other.example.com/tcp/4040"),
+	}
+
+	assert.Equal(t, []ma.Multiaddr{
+		ma.StringCast("/dns4/rdvp.example.com/tcp/4040"),
+		ma.StringCast("/dns4/rdvp.example.com/udp/4141/quic"),
+		ma.StringCast("/dns6/rdvp.example.com/tcp/4040"),
+		ma.StringCast("/dns4/other.example.com/tcp/4040"),
+	}, factory(in))
+}
+
+func TestPreferTransportAddrsFactory(t *testing.T) {
+	identity := func(ms []ma.Multiaddr) []ma.Multiaddr { return ms }
+
+	_, err := preferTransportAddrsFactory("carrier-pigeon", identity)
+	assert.Error(t, err)
+
+	factory, err := preferTransportAddrsFactory("quic", identity)
+	assert.NoError(t, err)
+
+	in := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/4040"),
+		ma.StringCast("/ip4/1.2.3.4/udp/4141/quic"),
+		ma.StringCast("/ip6/2001:db8::1/tcp/4040"),
+		ma.StringCast("/ip6/2001:db8::1/udp/4141/quic-v1"),
+	}
+
+	assert.Equal(t, []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/udp/4141/quic"),
+		ma.StringCast("/ip6/2001:db8::1/udp/4141/quic-v1"),
+		ma.StringCast("/ip4/1.2.3.4/tcp/4040"),
+		ma.StringCast("/ip6/2001:db8::1/tcp/4040"),
+	}, factory(in))
+}
+
+func TestCheckAnnounceDials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the closed port is one from a host already stopped
+	closed, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	closedAddrs := closed.Addrs()
+	require.NoError(t, closed.Close())
+
+	host, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.AddrsFactory(func(ms []ma.Multiaddr) []ma.Multiaddr { return append(ms, closedAddrs...) }),
+	)
+	require.NoError(t, err)
+	defer host.Close()
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	failed, err := checkAnnounceDials(ctx, zap.New(core), host, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, len(closedAddrs), failed)
+	assert.Equal(t, len(closedAddrs), logs.FilterMessage("announced address is not dialable").Len())
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpListenBacklog returns the backlog of the TCP listeners: Go listens
+// with net.core.somaxconn, which is also the kernel maximum.
+func tcpListenBacklog() (int, bool) {
+	data, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// tcpListenBacklog is only known on linux.
+func tcpListenBacklog() (int, bool) {
+	return 0, false
+}
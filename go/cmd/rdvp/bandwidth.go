@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	libp2p_relayproto "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+)
+
+// bandwidthPeriod holds the traffic of one accounting period.
+type bandwidthPeriod struct {
+	start time.Time
+
+	in, out   atomic.Int64
+	protocols map[protocol.ID]*bandwidthPeriodProtocol
+}
+
+type bandwidthPeriodProtocol struct {
+	in, out atomic.Int64
+}
+
+// periodBandwidthCounter is a metrics.Reporter which, on top of the libp2p
+// BandwidthCounter (rates, monotonic totals), accounts the traffic by
+// period. The flow meters of the BandwidthCounter are only updated once per
+// second, so they can't be read and reset without losing bytes, the period
+// counters are exact.
+type periodBandwidthCounter struct {
+	*metrics.BandwidthCounter
+
+	muPeriod sync.RWMutex
+	period   *bandwidthPeriod
+
+	// relayPeers, if set, accounts the relayed traffic by reserving peer,
+	// it must be set before the counter is used
+	relayPeers *relayPeerBandwidth
+
+	// transports, if set, accounts the traffic by transport, it must be set
+	// before the counter is used
+	transports *transportBandwidth
+}
+
+func newPeriodBandwidthCounter() *periodBandwidthCounter {
+	return &periodBandwidthCounter{
+		BandwidthCounter: metrics.NewBandwidthCounter(),
+		period:           newBandwidthPeriod(time.Now()),
+	}
+}
+
+func newBandwidthPeriod(start time.Time) *bandwidthPeriod {
+	return &bandwidthPeriod{
+		start:     start,
+		protocols: make(map[protocol.ID]*bandwidthPeriodProtocol),
+	}
+}
+
+func (c *periodBandwidthCounter) LogSentMessage(size int64) {
+	c.BandwidthCounter.LogSentMessage(size)
+
+	c.muPeriod.RLock()
+	c.period.out.Add(size)
+	c.muPeriod.RUnlock()
+}
+
+func (c *periodBandwidthCounter) LogRecvMessage(size int64) {
+	c.BandwidthCounter.LogRecvMessage(size)
+
+	c.muPeriod.RLock()
+	c.period.in.Add(size)
+	c.muPeriod.RUnlock()
+}
+
+func (c *periodBandwidthCounter) LogSentMessageStream(size int64, proto protocol.ID, p libp2p_peer.ID) {
+	c.BandwidthCounter.LogSentMessageStream(size, proto, p)
+	c.logProtocol(proto, func(bp *bandwidthPeriodProtocol) { bp.out.Add(size) })
+	c.logRelayPeer(proto, p, size)
+	if c.transports != nil {
+		c.transports.transport(proto, p).out.Add(size)
+	}
+}
+
+func (c *periodBandwidthCounter) LogRecvMessageStream(size int64, proto protocol.ID, p libp2p_peer.ID) {
+	c.BandwidthCounter.LogRecvMessageStream(size, proto, p)
+	c.logProtocol(proto, func(bp *bandwidthPeriodProtocol) { bp.in.Add(size) })
+	c.logRelayPeer(proto, p, size)
+	if c.transports != nil {
+		c.transports.transport(proto, p).in.Add(size)
+	}
+}
+
+func (c *periodBandwidthCounter) logRelayPeer(proto protocol.ID, p libp2p_peer.ID, size int64) {
+	if c.relayPeers != nil && proto == libp2p_relayproto.ProtoIDv2Stop {
+		c.relayPeers.add(p, size)
+	}
+}
+
+func (c *periodBandwidthCounter) logProtocol(proto protocol.ID, add func(bp *bandwidthPeriodProtocol)) {
+	c.muPeriod.RLock()
+	bp, ok := c.period.protocols[proto]
+	if ok {
+		add(bp)
+	}
+	c.muPeriod.RUnlock()
+
+	if ok {
+		return
+	}
+
+	c.muPeriod.Lock()
+	if bp, ok = c.period.protocols[proto]; !ok {
+		bp = &bandwidthPeriodProtocol{}
+		c.period.protocols[proto] = bp
+	}
+	add(bp)
+	c.muPeriod.Unlock()
+}
+
+type bandwidthStats struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+type bandwidthSnapshot struct {
+	Start     time.Time                 `json:"start"`
+	End       time.Time                 `json:"end"`
+	Total     bandwidthStats            `json:"total"`
+	Protocols map[string]bandwidthStats `json:"protocols"`
+}
+
+// snapshot returns the traffic of the current period, if reset is set the
+// period ends and a new one starts atomically.
+func (c *periodBandwidthCounter) snapshot(reset bool) bandwidthSnapshot {
+	now := time.Now()
+
+	if !reset {
+		c.muPeriod.RLock()
+		defer c.muPeriod.RUnlock()
+		return c.period.snapshot(now)
+	}
+
+	c.muPeriod.Lock()
+	period := c.period
+	c.period = newBandwidthPeriod(now)
+	c.muPeriod.Unlock()
+
+	// the writers only get the period under lock, nobody can update the
+	// previous one anymore
+	return period.snapshot(now)
+}
+
+func (p *bandwidthPeriod) snapshot(end time.Time) bandwidthSnapshot {
+	snap := bandwidthSnapshot{
+		Start:     p.start,
+		End:       end,
+		Total:     bandwidthStats{In: p.in.Load(), Out: p.out.Load()},
+		Protocols: make(map[string]bandwidthStats, len(p.protocols)),
+	}
+
+	for proto, bp := range p.protocols {
+		snap.Protocols[string(proto)] = bandwidthStats{In: bp.in.Load(), Out: bp.out.Load()}
+	}
+
+	return snap
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	libp2p_relayproto "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ metrics.Reporter = (*periodBandwidthCounter)(nil)
+
+func TestPeriodBandwidthCounter(t *testing.T) {
+	c := newPeriodBandwidthCounter()
+	p := libp2p_peer.ID("peer")
+
+	c.LogSentMessage(10)
+	c.LogRecvMessage(20)
+	c.LogSentMessageStream(3, "/proto/a", p)
+	c.LogRecvMessageStream(4, "/proto/a", p)
+	c.LogRecvMessageStream(5, "/proto/b", p)
+
+	snap := c.snapshot(false)
+	assert.Equal(t, bandwidthStats{In: 20, Out: 10}, snap.Total)
+	assert.Equal(t, map[string]bandwidthStats{
+		"/proto/a": {In: 4, Out: 3},
+		"/proto/b": {In: 5},
+	}, snap.Protocols)
+
+	// reading without reset keeps the period
+	assert.Equal(t, snap.Total, c.snapshot(false).Total)
+
+	reset := c.snapshot(true)
+	assert.Equal(t, snap.Total, reset.Total)
+	assert.Equal(t, snap.Start, reset.Start)
+
+	c.LogSentMessage(1)
+
+	next := c.snapshot(true)
+	assert.Equal(t, bandwidthStats{Out: 1}, next.Total)
+	assert.Empty(t, next.Protocols)
+	assert.Equal(t, reset.End, next.Start)
+}
+
+func TestRelayPeerBandwidth(t *testing.T) {
+	c := newPeriodBandwidthCounter()
+	c.relayPeers = newRelayPeerBandwidth()
+	a, b, d := libp2p_peer.ID("a"), libp2p_peer.ID("b"), libp2p_peer.ID("d")
+
+	c.LogSentMessageStream(10, libp2p_relayproto.ProtoIDv2Stop, a)
+	c.LogRecvMessageStream(5, libp2p_relayproto.ProtoIDv2Stop, a)
+	c.LogSentMessageStream(30, libp2p_relayproto.ProtoIDv2Stop, b)
+	c.LogSentMessageStream(1, libp2p_relayproto.ProtoIDv2Stop, d)
+	// only the stop streams are accounted
+	c.LogSentMessageStream(100, libp2p_relayproto.ProtoIDv2Hop, d)
+
+	assert.Empty(t, c.relayPeers.top(), "nothing before the end of the first window")
+
+	c.relayPeers.rotate(2)
+	assert.Equal(t, []relayPeerBytes{{Peer: b, Bytes: 30}, {Peer: a, Bytes: 15}}, c.relayPeers.top())
+
+	c.relayPeers.rotate(2)
+	assert.Empty(t, c.relayPeers.top())
+}
+
+func TestTransportOf(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4040":                      transportTCP,
+		"/ip4/1.2.3.4/udp/4141/quic":                 transportQUIC,
+		"/ip4/1.2.3.4/udp/4141/quic-v1":              transportQUIC,
+		"/ip4/1.2.3.4/udp/4141/quic-v1/webtransport": transportWebTransport,
+		"/ip4/1.2.3.4/tcp/443/wss":                   transportWebsocket,
+		"/ip4/1.2.3.4/tcp/4040/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit": transportCircuit,
+		"/ip4/1.2.3.4/udp/4040": transportUnknown,
+	} {
+		assert.Equal(t, expected, transportOf(ma.StringCast(addr)), addr)
+	}
+}
+
+func TestTransportBandwidth(t *testing.T) {
+	c := newPeriodBandwidthCounter()
+	c.transports = newTransportBandwidth()
+	p := libp2p_peer.ID("peer")
+
+	c.LogSentMessageStream(3, libp2p_relayproto.ProtoIDv2Stop, p)
+	c.LogRecvMessageStream(4, libp2p_relayproto.ProtoIDv2Hop, p)
+	// no network, the transport of the peer is unknown
+	c.LogRecvMessageStream(5, "/proto/a", p)
+
+	totals := c.transports.totals()
+	assert.Len(t, totals, len(bandwidthTransports))
+	assert.Equal(t, bandwidthStats{In: 4, Out: 3}, totals[transportRelay])
+	assert.Equal(t, bandwidthStats{In: 5}, totals[transportUnknown])
+	assert.Equal(t, bandwidthStats{}, totals[transportTCP])
+}
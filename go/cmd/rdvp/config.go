@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ff "github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffyaml"
+	"go.uber.org/zap"
+)
+
+// stringList is a repeatable flag, each value can also be a comma separated
+// list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// pathList is a repeatable flag of paths, unlike stringList the values are
+// not split on commas, which are valid in paths.
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pathList) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty path")
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// loadConfigFiles sets the flags of fs from the given config files, later
+// files override earlier ones. Flags already set (from the command line or
+// the environment) are left untouched, so the precedence is:
+// defaults < files[0] < ... < files[n] < env < command line.
+func loadConfigFiles(fs *flag.FlagSet, files []string) error {
+	provided := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		provided[f.Name] = true
+	})
+
+	var names []string
+	values := map[string][]string{}
+	for _, file := range files {
+		fileValues, err := readConfigFile(file)
+		if err != nil {
+			return err
+		}
+
+		for name, vs := range fileValues {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("config file `%s`: flag %q not defined in flag set", file, name)
+			}
+
+			if _, ok := values[name]; !ok {
+				names = append(names, name)
+			}
+			values[name] = vs
+		}
+	}
+
+	for _, name := range names {
+		if provided[name] {
+			continue
+		}
+
+		for _, value := range values[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("error setting flag %q from config file: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagSource is where the value of a flag comes from.
+type flagSource string
+
+const (
+	flagSourceDefault    flagSource = "default"
+	flagSourceConfigFile flagSource = "config-file"
+	flagSourceEnv        flagSource = "env"
+	flagSourceCLI        flagSource = "cli"
+)
+
+// resolveFlags sets the flags of fs not given on the command line from the
+// env vars with envPrefix, then from configFiles (see loadConfigFiles). It
+// returns the source of each flag of fs.
+func resolveFlags(fs *flag.FlagSet, envPrefix string, configFiles *pathList) (map[string]flagSource, error) {
+	sources := make(map[string]flagSource)
+	record := func(source flagSource) func(*flag.Flag) {
+		return func(f *flag.Flag) {
+			if _, ok := sources[f.Name]; !ok {
+				sources[f.Name] = source
+			}
+		}
+	}
+
+	// fs is already parsed, only the env vars are applied
+	fs.Visit(record(flagSourceCLI))
+	if err := ff.Parse(fs, nil, ff.WithEnvVarPrefix(envPrefix)); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(record(flagSourceEnv))
+	if err := loadConfigFiles(fs, *configFiles); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(record(flagSourceConfigFile))
+	fs.VisitAll(record(flagSourceDefault))
+
+	return sources, nil
+}
+
+// logFlagSources logs the resolved value of each flag of fs, secrets are
+// redacted, and its source.
+func logFlagSources(logger *zap.Logger, fs *flag.FlagSet, sources map[string]flagSource) {
+	config := redactedConfig(fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		logger.Info("flag",
+			zap.String("name", f.Name),
+			zap.String("value", config[f.Name]),
+			zap.String("source", string(sources[f.Name])))
+	})
+}
+
+// configFileParser returns the parser of a config file from its extension:
+// JSON (.json), YAML (.yaml, .yml) or `flag value` lines for the others. In
+// JSON and YAML, the keys are the flag names and an array sets a repeatable
+// flag several times.
+func configFileParser(file string) ff.ConfigFileParser {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return ff.JSONParser
+	case ".yaml", ".yml":
+		return ffyaml.Parser
+	default:
+		return ff.PlainParser
+	}
+}
+
+func readConfigFile(file string) (map[string][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string][]string{}
+	err = configFileParser(file)(f, func(name, value string) error {
+		values[name] = append(values[name], value)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file `%s`: %w", file, err)
+	}
+
+	return values, nil
+}
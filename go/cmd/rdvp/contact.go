@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// contactProtocolID is the protocol used to advertise the operator contact
+// information: once the stream is opened, the server writes the contact
+// string (utf-8, at most contactMaxLength bytes) and closes the stream.
+const contactProtocolID = protocol.ID("/berty/rdvp/contact/1.0.0")
+
+const contactMaxLength = 1024
+
+func contactHandler(logger *zap.Logger, contact string) libp2p_network.StreamHandler {
+	return func(s libp2p_network.Stream) {
+		defer s.Close()
+
+		if _, err := io.WriteString(s, contact); err != nil {
+			logger.Debug("unable to write contact info", zap.Error(err))
+			_ = s.Reset()
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// contactCard describes how to reach a rdvp node, it is signed by the node
+// identity so it can be shared through untrusted channels (ie. a QR code on
+// a website).
+type contactCard struct {
+	PeerID    string   `json:"peer_id"`
+	Addrs     []string `json:"addrs"`
+	Protocols []string `json:"protocols"`
+	Contact   string   `json:"contact,omitempty"`
+	// Signature is the base64 encoded signature, by the peer ID key, of the
+	// JSON encoding of the card without the signature.
+	Signature string `json:"signature,omitempty"`
+}
+
+// newContactCard returns the card of the node identified by priv, signed.
+// The announced addrs are completed with the /p2p component so they can be
+// dialed as is.
+func newContactCard(priv libp2p_ci.PrivKey, announces []ma.Multiaddr, protocols []protocol.ID, contact string) (*contactCard, error) {
+	if len(announces) == 0 {
+		return nil, fmt.Errorf("no announced addrs")
+	}
+
+	id, err := libp2p_peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := libp2p_peer.AddrInfoToP2pAddrs(&libp2p_peer.AddrInfo{ID: id, Addrs: announces})
+	if err != nil {
+		return nil, err
+	}
+
+	card := &contactCard{
+		PeerID:    id.String(),
+		Addrs:     make([]string, len(addrs)),
+		Protocols: make([]string, len(protocols)),
+		Contact:   contact,
+	}
+	for i, addr := range addrs {
+		card.Addrs[i] = addr.String()
+	}
+	for i, proto := range protocols {
+		card.Protocols[i] = string(proto)
+	}
+
+	payload, err := card.signedPayload()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := priv.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+	card.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	return card, nil
+}
+
+func (c contactCard) signedPayload() ([]byte, error) {
+	c.Signature = ""
+	return json.Marshal(c)
+}
+
+// verify checks that the card is signed by the key of its peer ID, it only
+// works with the keys inlined in the peer ID (ie. Ed25519, not RSA).
+func (c *contactCard) verify() error {
+	id, err := libp2p_peer.Decode(c.PeerID)
+	if err != nil {
+		return err
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("unable to get the public key of `%s`: %w", c.PeerID, err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return err
+	}
+	payload, err := c.signedPayload()
+	if err != nil {
+		return err
+	}
+
+	ok, err := pub.Verify(payload, sig)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return fmt.Errorf("invalid contact card signature")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/json"
+	"testing"
+
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactCard(t *testing.T) {
+	priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	announces := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4040")}
+	protocols := []protocol.ID{libp2p_rp.RendezvousProto, contactProtocolID}
+
+	_, err = newContactCard(priv, nil, protocols, "")
+	require.Error(t, err)
+
+	card, err := newContactCard(priv, announces, protocols, "abuse@example.com")
+	require.NoError(t, err)
+	require.NoError(t, card.verify())
+	require.Len(t, card.Addrs, 1)
+	assert.Equal(t, "/ip4/1.2.3.4/tcp/4040/p2p/"+card.PeerID, card.Addrs[0])
+	assert.Equal(t, []string{string(libp2p_rp.RendezvousProto), string(contactProtocolID)}, card.Protocols)
+
+	// the card survives a round trip through its JSON encoding
+	raw, err := json.Marshal(card)
+	require.NoError(t, err)
+	var decoded contactCard
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	require.NoError(t, decoded.verify())
+
+	decoded.Contact = "attacker@example.com"
+	assert.Error(t, decoded.verify())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+)
+
+const memoryDBURN = ":memory:"
+
+// setDBJournalMode switches the journal mode of the sqlite database at urn.
+//
+// The database connection of libp2p_rpdb is not reachable, so only the
+// journal modes persisted in the database file can be applied from here:
+//   - `wal`: writers don't block readers and commits only append to the
+//     write-ahead log, a lot less fsync but the last transactions can be
+//     lost on power failure.
+//   - `delete`: sqlite default (rollback journal), every commit is durable.
+//
+// It returns the journal mode in effect after the change.
+func setDBJournalMode(ctx context.Context, urn string, mode string) (string, error) {
+	mode = strings.ToLower(mode)
+	switch mode {
+	case "wal", "delete":
+	default:
+		return "", fmt.Errorf("unsupported journal mode `%s`, should be one of: wal, delete", mode)
+	}
+
+	if urn == memoryDBURN {
+		return "", fmt.Errorf("journal mode cannot be set on an in-memory database")
+	}
+
+	db, err := sql.Open("sqlite3", urn)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var effective string
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode="+mode).Scan(&effective); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(effective), nil
+}
+
+// checkDBIntegrity runs `PRAGMA integrity_check` on the sqlite database at
+// urn. It returns the problems found, empty if the database is sound.
+func checkDBIntegrity(ctx context.Context, urn string) ([]string, error) {
+	if urn == memoryDBURN {
+		return nil, fmt.Errorf("integrity cannot be checked on an in-memory database")
+	}
+
+	db, err := sql.Open("sqlite3", urn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+
+	return problems, rows.Err()
+}
+
+// dbDSN returns the DSN of the sqlite database at urn with the synchronous
+// mode, applied by the driver on each connection of the DSN:
+//   - `full`: fsync at each commit, every commit is durable.
+//   - `normal`: sqlcipher driver default, fewer fsync, with the `wal` journal
+//     mode the last commits can be lost on power failure but the db is not
+//     corrupted.
+//   - `off`: no fsync, the db can be corrupted on power failure or OS crash.
+//
+// An empty mode keeps the driver default, urn is returned as is.
+func dbDSN(urn string, synchronous string) (string, error) {
+	if synchronous == "" {
+		return urn, nil
+	}
+
+	synchronous = strings.ToLower(synchronous)
+	switch synchronous {
+	case "off", "normal", "full":
+	default:
+		return "", fmt.Errorf("unsupported synchronous mode `%s`, should be one of: off, normal, full", synchronous)
+	}
+
+	if urn == memoryDBURN {
+		return "", fmt.Errorf("synchronous mode cannot be set on an in-memory database")
+	}
+
+	return urn + "?_sync=" + synchronous, nil
+}
+
+// openRendezvousDB opens the rendezvous database at urn through dsn (see
+// dbDSN).
+//
+// libp2p_rpdb.OpenDB stats its argument to know whether it must create the
+// database, and never finds a DSN with parameters: an existing database is
+// exposed to it through a symlink named after dsn while it is opened. The
+// driver strips the parameters, so the connections use urn.
+func openRendezvousDB(ctx context.Context, urn string, dsn string) (*libp2p_rpdb.DB, error) {
+	if dsn == urn {
+		return libp2p_rpdb.OpenDB(ctx, urn)
+	}
+
+	if _, err := os.Stat(urn); err == nil {
+		if err := os.Symlink(filepath.Base(urn), dsn); err != nil {
+			return nil, err
+		}
+		defer os.Remove(dsn)
+	}
+
+	return libp2p_rpdb.OpenDB(ctx, dsn)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDBIntegrity(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rdvp.db")
+	db, err := libp2p_rpdb.OpenDB(ctx, path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	problems, err := checkDBIntegrity(ctx, path)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	_, err = checkDBIntegrity(ctx, memoryDBURN)
+	require.Error(t, err)
+
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, bytes.Repeat([]byte("garbage!"), 1024), 0o600))
+	_, err = checkDBIntegrity(ctx, garbage)
+	require.Error(t, err)
+}
+
+func TestDBModes(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rdvp.db")
+	rdb, err := libp2p_rpdb.OpenDB(ctx, path)
+	require.NoError(t, err)
+	require.NoError(t, rdb.Close())
+
+	for _, mode := range []string{"wal", "DELETE"} {
+		effective, err := setDBJournalMode(ctx, path, mode)
+		require.NoError(t, err)
+		require.Equal(t, strings.ToLower(mode), effective)
+	}
+	_, err = setDBJournalMode(ctx, path, "memory")
+	require.Error(t, err)
+
+	synchronous := func(dsn string) (mode int) {
+		db, err := sql.Open("sqlite3", dsn)
+		require.NoError(t, err)
+		defer db.Close()
+		require.NoError(t, db.QueryRowContext(ctx, "PRAGMA synchronous").Scan(&mode))
+		return mode
+	}
+
+	for mode, value := range map[string]int{"": 1, "off": 0, "NORMAL": 1, "full": 2} {
+		dsn, err := dbDSN(path, mode)
+		require.NoError(t, err)
+
+		// the existing db is loaded, not created again
+		rdb, err := openRendezvousDB(ctx, path, dsn)
+		require.NoError(t, err, mode)
+		require.NoError(t, rdb.Close())
+		if dsn != path {
+			_, err = os.Lstat(dsn)
+			require.ErrorIs(t, err, os.ErrNotExist, "symlink left behind")
+		}
+
+		require.Equal(t, value, synchronous(dsn), mode)
+	}
+	// the other connections keep the driver default
+	require.Equal(t, 1, synchronous(path))
+
+	_, err = dbDSN(path, "extra")
+	require.Error(t, err)
+	_, err = dbDSN(memoryDBURN, "full")
+	require.Error(t, err)
+
+	// a new db is created through the DSN
+	newPath := filepath.Join(t.TempDir(), "new.db")
+	dsn, err := dbDSN(newPath, "full")
+	require.NoError(t, err)
+	rdb, err = openRendezvousDB(ctx, newPath, dsn)
+	require.NoError(t, err)
+	require.NoError(t, rdb.Close())
+}
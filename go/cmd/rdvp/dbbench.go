@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// dbBenchNamespacePrefix prefixes the namespaces of the benchmark
+// registrations, to tell them from the real ones.
+const dbBenchNamespacePrefix = "rdvp-db-bench/"
+
+type dbBenchOptions struct {
+	Registrations int
+	Queries       int
+	Churn         int
+	Namespaces    int
+	Cleanup       bool
+}
+
+// dbBenchResult holds the latencies of the operations of a kind.
+type dbBenchResult struct {
+	Op        string
+	Latencies []time.Duration
+	Elapsed   time.Duration
+}
+
+func (r *dbBenchResult) observe(start time.Time) {
+	d := time.Since(start)
+	r.Latencies = append(r.Latencies, d)
+	r.Elapsed += d
+}
+
+func (r *dbBenchResult) opsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(len(r.Latencies)) / r.Elapsed.Seconds()
+}
+
+// percentile returns the latency under which fall p percents of the
+// operations, Latencies must be sorted.
+func (r *dbBenchResult) percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+
+	i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+	switch {
+	case i < 0:
+		i = 0
+	case i >= len(r.Latencies):
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// runDBBench runs the workload sequentially on db: the registrations, the
+// discoveries of random namespaces, then the churn (unregistration and
+// registration of a random peer).
+func runDBBench(ctx context.Context, db libp2p_rpdbi.DB, opts dbBenchOptions) ([]*dbBenchResult, error) {
+	if opts.Registrations <= 0 || opts.Namespaces <= 0 {
+		return nil, fmt.Errorf("the workload needs registrations and namespaces")
+	}
+
+	addrs := [][]byte{
+		ma.StringCast("/ip4/1.2.3.4/tcp/4040").Bytes(),
+		ma.StringCast("/ip4/1.2.3.4/udp/4040/quic-v1").Bytes(),
+	}
+	namespace := func(i int) string {
+		return fmt.Sprintf("%s%d", dbBenchNamespacePrefix, i%opts.Namespaces)
+	}
+
+	peers := make([]libp2p_peer.ID, opts.Registrations)
+	for i := range peers {
+		priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if peers[i], err = libp2p_peer.IDFromPrivateKey(priv); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		register   = &dbBenchResult{Op: "register"}
+		discover   = &dbBenchResult{Op: "discover"}
+		unregister = &dbBenchResult{Op: "unregister"}
+		cleanup    = &dbBenchResult{Op: "cleanup"}
+	)
+
+	for i, p := range peers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		if _, err := db.Register(p, namespace(i), addrs, int(time.Hour/time.Second)); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+		register.observe(start)
+	}
+
+	for i := 0; i < opts.Queries; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ns := namespace(rand.Intn(opts.Namespaces)) // nolint:gosec
+		start := time.Now()
+		if _, _, err := db.Discover(ns, nil, 100); err != nil {
+			return nil, fmt.Errorf("discover: %w", err)
+		}
+		discover.observe(start)
+	}
+
+	for i := 0; i < opts.Churn; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n := rand.Intn(len(peers)) // nolint:gosec
+		start := time.Now()
+		if err := db.Unregister(peers[n], namespace(n)); err != nil {
+			return nil, fmt.Errorf("unregister: %w", err)
+		}
+		unregister.observe(start)
+
+		start = time.Now()
+		if _, err := db.Register(peers[n], namespace(n), addrs, int(time.Hour/time.Second)); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+		register.observe(start)
+	}
+
+	results := []*dbBenchResult{register, discover, unregister}
+	if opts.Cleanup {
+		for _, p := range peers {
+			start := time.Now()
+			if err := db.Unregister(p, ""); err != nil {
+				return nil, fmt.Errorf("cleanup: %w", err)
+			}
+			cleanup.observe(start)
+		}
+		results = append(results, cleanup)
+	}
+
+	for _, r := range results {
+		sort.Slice(r.Latencies, func(i, j int) bool { return r.Latencies[i] < r.Latencies[j] })
+	}
+
+	return results, nil
+}
+
+func printDBBenchResults(w io.Writer, results []*dbBenchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\tops/s\tp50\tp90\tp99\tmax\t")
+	for _, r := range results {
+		if len(r.Latencies) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%s\t%s\t%s\t%s\t\n", r.Op, len(r.Latencies), r.opsPerSec(),
+			r.percentile(50), r.percentile(90), r.percentile(99), r.Latencies[len(r.Latencies)-1])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDBBench(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	results, err := runDBBench(ctx, db, dbBenchOptions{
+		Registrations: 20,
+		Queries:       10,
+		Churn:         5,
+		Namespaces:    3,
+		Cleanup:       true,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Op] = len(r.Latencies)
+	}
+	assert.Equal(t, map[string]int{"register": 25, "discover": 10, "unregister": 5, "cleanup": 20}, counts)
+
+	regs, _, err := db.Discover("", nil, 100)
+	require.NoError(t, err)
+	assert.Empty(t, regs)
+}
+
+func TestDBBenchPercentile(t *testing.T) {
+	r := &dbBenchResult{}
+	for i := 1; i <= 100; i++ {
+		r.Latencies = append(r.Latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, r.percentile(50))
+	assert.Equal(t, 99*time.Millisecond, r.percentile(99))
+	assert.Equal(t, 100*time.Millisecond, r.percentile(100))
+	assert.Equal(t, time.Duration(0), (&dbBenchResult{}).percentile(50))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	dependencyProbeInterval = 30 * time.Second
+	dependencyProbeTimeout  = 5 * time.Second
+)
+
+// probeDependency dials the TCP endpoint of the dependency at rawurl (ie.
+// tcp://127.0.0.1:8080) every interval until ctx is done, and sets up to 1
+// when it is reachable, 0 otherwise.
+func probeDependency(ctx context.Context, logger *zap.Logger, up prometheus.Gauge, rawurl string, interval time.Duration) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	reachable := true
+	for {
+		dctx, cancel := context.WithTimeout(ctx, dependencyProbeTimeout)
+		conn, err := dialer.DialContext(dctx, "tcp", u.Host)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			up.Set(0)
+			if reachable {
+				logger.Warn("dependency unreachable", zap.String("addr", u.Host), zap.Error(err))
+			}
+			reachable = false
+		default:
+			conn.Close()
+			up.Set(1)
+			if !reachable {
+				logger.Info("dependency reachable again", zap.String("addr", u.Host))
+			}
+			reachable = true
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// cachedDB serves the discoveries without cookie of the most recently
+// queried namespaces from memory, for at most ttl. The other operations go
+// straight to the wrapped DB.
+//
+// A registration or unregistration drops the cached results of its
+// namespace, and of the "all namespaces" query, before returning, so it is
+// visible to the discoveries made once it is acknowledged. The expired
+// registrations are filtered out of the cached results like the DB does,
+// the ones removed by other means (another process) stay visible for up to
+// ttl.
+type cachedDB struct {
+	libp2p_rpdbi.DB
+
+	metrics *rdvpMetrics
+	size    int
+	ttl     time.Duration
+
+	muCache sync.Mutex
+	lru     *list.List // of *discoveryCacheEntry, most recent first
+	entries map[string]*list.Element
+	// generation is incremented by each invalidation, a result is only
+	// cached if no invalidation happened while it was queried.
+	generation uint64
+}
+
+var _ libp2p_rpdbi.DB = (*cachedDB)(nil)
+
+type discoveryCacheEntry struct {
+	ns string
+	// results by limit
+	results map[int]cachedDiscovery
+}
+
+type cachedDiscovery struct {
+	regs    []libp2p_rpdbi.RegistrationRecord
+	cookie  []byte
+	queried time.Time
+}
+
+func newCachedDB(metrics *rdvpMetrics, db libp2p_rpdbi.DB, size int, ttl time.Duration) *cachedDB {
+	return &cachedDB{
+		DB:      db,
+		metrics: metrics,
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (db *cachedDB) Register(p libp2p_peer.ID, ns string, addrs [][]byte, ttl int) (uint64, error) {
+	counter, err := db.DB.Register(p, ns, addrs, ttl)
+	db.invalidate(ns)
+	return counter, err
+}
+
+func (db *cachedDB) Unregister(p libp2p_peer.ID, ns string) error {
+	err := db.DB.Unregister(p, ns)
+	db.invalidate(ns)
+	return err
+}
+
+func (db *cachedDB) Discover(ns string, cookie []byte, limit int) ([]libp2p_rpdbi.RegistrationRecord, []byte, error) {
+	if len(cookie) > 0 {
+		return db.DB.Discover(ns, cookie, limit)
+	}
+
+	now := time.Now()
+	if regs, rcookie, ok := db.get(ns, limit, now); ok {
+		db.metrics.discoveryCacheHits.Inc()
+		return regs, rcookie, nil
+	}
+	db.metrics.discoveryCacheMisses.Inc()
+
+	db.muCache.Lock()
+	generation := db.generation
+	db.muCache.Unlock()
+
+	regs, rcookie, err := db.DB.Discover(ns, cookie, limit)
+	if err != nil {
+		return regs, rcookie, err
+	}
+
+	db.put(ns, limit, generation, cachedDiscovery{regs: regs, cookie: rcookie, queried: now})
+	return regs, rcookie, nil
+}
+
+func (db *cachedDB) get(ns string, limit int, now time.Time) ([]libp2p_rpdbi.RegistrationRecord, []byte, bool) {
+	db.muCache.Lock()
+	defer db.muCache.Unlock()
+
+	elem, ok := db.entries[ns]
+	if !ok {
+		return nil, nil, false
+	}
+
+	entry := elem.Value.(*discoveryCacheEntry)
+	res, ok := entry.results[limit]
+	if !ok {
+		return nil, nil, false
+	}
+
+	age := now.Sub(res.queried)
+	if age >= db.ttl {
+		delete(entry.results, limit)
+		return nil, nil, false
+	}
+
+	db.lru.MoveToFront(elem)
+
+	// the records are shared by the hits, copy them to update the ttls.
+	// The DB computes the ttls from unix seconds (expire - now), so do
+	// the same: a registration is dropped exactly when the DB would stop
+	// returning it.
+	elapsed := int(now.Unix() - res.queried.Unix())
+	regs := make([]libp2p_rpdbi.RegistrationRecord, 0, len(res.regs))
+	for _, reg := range res.regs {
+		if reg.Ttl <= elapsed {
+			continue
+		}
+		reg.Ttl -= elapsed
+		regs = append(regs, reg)
+	}
+
+	return regs, res.cookie, true
+}
+
+func (db *cachedDB) put(ns string, limit int, generation uint64, res cachedDiscovery) {
+	db.muCache.Lock()
+	defer db.muCache.Unlock()
+
+	if generation != db.generation {
+		return
+	}
+
+	if elem, ok := db.entries[ns]; ok {
+		elem.Value.(*discoveryCacheEntry).results[limit] = res
+		db.lru.MoveToFront(elem)
+		return
+	}
+
+	entry := &discoveryCacheEntry{ns: ns, results: map[int]cachedDiscovery{limit: res}}
+	db.entries[ns] = db.lru.PushFront(entry)
+
+	for db.lru.Len() > db.size {
+		oldest := db.lru.Back()
+		db.lru.Remove(oldest)
+		delete(db.entries, oldest.Value.(*discoveryCacheEntry).ns)
+	}
+}
+
+// invalidate drops the cached results affected by a change in ns, an
+// empty ns (unregistration from all the namespaces) drops everything.
+func (db *cachedDB) invalidate(ns string) {
+	db.muCache.Lock()
+	defer db.muCache.Unlock()
+
+	db.generation++
+
+	if ns == "" {
+		db.lru.Init()
+		db.entries = make(map[string]*list.Element)
+		return
+	}
+
+	for _, key := range []string{ns, ""} {
+		if elem, ok := db.entries[key]; ok {
+			db.lru.Remove(elem)
+			delete(db.entries, key)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedDB(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer primary.Close()
+
+	metrics := newRdvpMetrics()
+	db := newCachedDB(metrics, primary, 1, time.Minute)
+
+	newPeer := func() libp2p_peer.ID {
+		priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+		p, err := libp2p_peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		return p
+	}
+
+	addrs := [][]byte{ma.StringCast("/ip4/1.2.3.4/tcp/4040").Bytes()}
+	p1, p2 := newPeer(), newPeer()
+
+	_, err = db.Register(p1, "ns", addrs, 60)
+	require.NoError(t, err)
+
+	regs, _, err := db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+
+	regs, _, err = db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.discoveryCacheHits))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.discoveryCacheMisses))
+
+	// a registration is visible right after it returns
+	_, err = db.Register(p2, "ns", addrs, 60)
+	require.NoError(t, err)
+
+	regs, _, err = db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.discoveryCacheMisses))
+
+	// and so is an unregistration from all the namespaces
+	require.NoError(t, db.Unregister(p1, ""))
+
+	regs, _, err = db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.discoveryCacheMisses))
+
+	// the least recently used namespace is evicted
+	_, _, err = db.Discover("other", nil, 10)
+	require.NoError(t, err)
+
+	_, _, err = db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.discoveryCacheMisses))
+}
+
+func TestCachedDBStaleQuery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer primary.Close()
+
+	db := newCachedDB(newRdvpMetrics(), primary, 10, time.Minute)
+
+	// a result queried before an invalidation is not cached
+	generation := db.generation
+	db.invalidate("ns")
+	db.put("ns", 10, generation, cachedDiscovery{queried: time.Now()})
+
+	_, _, ok := db.get("ns", 10, time.Now())
+	assert.False(t, ok)
+}
+
+func TestCachedDBExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer primary.Close()
+
+	db := newCachedDB(newRdvpMetrics(), primary, 10, time.Hour)
+
+	// queried at the end of a second, with the DB ttls computed from unix
+	// seconds: a 2s ttl expires at the start of the 2nd next second
+	queried := time.Unix(1000, int64(900*time.Millisecond))
+	db.put("ns", 10, db.generation, cachedDiscovery{
+		regs:    []libp2p_rpdbi.RegistrationRecord{{Ns: "ns", Ttl: 2}},
+		queried: queried,
+	})
+
+	regs, _, ok := db.get("ns", 10, queried.Add(200*time.Millisecond))
+	require.True(t, ok)
+	require.Len(t, regs, 1)
+	assert.Equal(t, 1, regs[0].Ttl)
+
+	regs, _, ok = db.get("ns", 10, queried.Add(1200*time.Millisecond))
+	require.True(t, ok)
+	assert.Empty(t, regs)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// validateEmitterOptions checks the emitter flags without contacting the
+// servers: each emitter sync driver needs a server and its admin key, given
+// in the same order.
+func validateEmitterOptions(servers, adminKeys []string, publicAddr string) error {
+	if len(servers) != len(adminKeys) {
+		return fmt.Errorf("-emitter-server and -emitter-admin-key must be set together, got %d servers and %d admin keys", len(servers), len(adminKeys))
+	}
+
+	for _, server := range servers {
+		if err := validateEmitterAddr("emitter-server", server); err != nil {
+			return err
+		}
+	}
+
+	if publicAddr != "" {
+		return validateEmitterAddr("emitter-public-addr", publicAddr)
+	}
+
+	return nil
+}
+
+func validateEmitterAddr(flag, addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -%s: %w", flag, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid -%s `%s`: expected scheme://host:port", flag, addr)
+	}
+	return nil
+}
+
+// dryRunSummary is what serve -dry-run validated.
+type dryRunSummary struct {
+	PeerID    libp2p_peer.ID
+	RandomKey bool
+	// AutosaveKey is the path where -pk-autosave would generate the key
+	AutosaveKey string
+	Listeners   []ma.Multiaddr
+	Announces   []ma.Multiaddr
+	Emitters    []string
+}
+
+func (s dryRunSummary) print(w io.Writer) {
+	fmt.Fprintln(w, "config OK")
+
+	switch {
+	case s.RandomKey:
+		fmt.Fprintf(w, "  peer ID:   %s (random, no key set)\n", s.PeerID)
+	case s.AutosaveKey != "":
+		fmt.Fprintf(w, "  peer ID:   random, would generate a key in %s\n", s.AutosaveKey)
+	default:
+		fmt.Fprintf(w, "  peer ID:   %s\n", s.PeerID)
+	}
+
+	for _, l := range s.Listeners {
+		fmt.Fprintf(w, "  listener:  %s\n", l)
+	}
+
+	for _, a := range s.Announces {
+		fmt.Fprintf(w, "  announce:  %s\n", a)
+	}
+
+	for _, e := range s.Emitters {
+		fmt.Fprintf(w, "  emitter:   %s\n", e)
+	}
+
+	if len(s.Emitters) == 0 {
+		fmt.Fprintln(w, "  emitter:   disabled")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	// default connection manager watermarks and grace period, the libp2p
+	// defaults
+	connMgrLow   = 160
+	connMgrHigh  = 192
+	connMgrGrace = time.Minute
+
+	// connLogInterval is the interval between two logs of the open
+	// connections count
+	connLogInterval = 5 * time.Minute
+
+	protectedPeerTag = "rdvp-protected"
+)
+
+func parseProtectedPeers(s string) ([]libp2p_peer.ID, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var peers []libp2p_peer.ID
+	for _, id := range strings.Split(s, ",") {
+		p, err := libp2p_peer.Decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected peer `%s`: %w", id, err)
+		}
+		peers = append(peers, p)
+	}
+
+	return peers, nil
+}
+
+func validateConnMgrOptions(low, high int, grace time.Duration) error {
+	switch {
+	case low < 0 || high <= 0:
+		return fmt.Errorf("the connection manager watermarks must be positive")
+	case low >= high:
+		return fmt.Errorf("the connection manager low watermark (%d) must be lower than the high one (%d)", low, high)
+	case grace < 0:
+		return fmt.Errorf("the connection manager grace period must be positive")
+	}
+	return nil
+}
+
+// logConnections logs the number of open connections every interval.
+func logConnections(ctx context.Context, logger *zap.Logger, n libp2p_network.Network, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		logger.Info("open connections", zap.Int("conns", len(n.Conns())), zap.Int("peers", len(n.Peers())))
+	}
+}
+
+// protectedConnEvictor makes room for the protected peers: when a protected
+// peer connects while the node is above the high watermark, the least
+// recently active unprotected connection is closed. The connection manager
+// never trims the protected peers by itself.
+type protectedConnEvictor struct {
+	logger  *zap.Logger
+	metrics *rdvpMetrics
+	host    libp2p_host.Host
+	idle    *idleTracker
+	high    int
+}
+
+func newProtectedConnEvictor(logger *zap.Logger, metrics *rdvpMetrics, host libp2p_host.Host, idle *idleTracker, high int) *protectedConnEvictor {
+	e := &protectedConnEvictor{
+		logger:  logger,
+		metrics: metrics,
+		host:    host,
+		idle:    idle,
+		high:    high,
+	}
+
+	host.Network().Notify(&libp2p_network.NotifyBundle{
+		ConnectedF: func(_ libp2p_network.Network, c libp2p_network.Conn) {
+			if e.protected(c.RemotePeer()) {
+				// don't block the notifications
+				go e.makeRoom()
+			}
+		},
+	})
+
+	return e
+}
+
+func (e *protectedConnEvictor) protected(p libp2p_peer.ID) bool {
+	return e.host.ConnManager().IsProtected(p, "")
+}
+
+func (e *protectedConnEvictor) makeRoom() {
+	conns := e.host.Network().Conns()
+	if len(conns) <= e.high {
+		return
+	}
+
+	var (
+		victim     libp2p_network.Conn
+		victimLast time.Time
+	)
+	for _, c := range conns {
+		if e.protected(c.RemotePeer()) {
+			continue
+		}
+
+		// connections without rendezvous activity count from their opening
+		last, ok := e.idle.lastActivity(c)
+		if !ok {
+			last = c.Stat().Opened
+		}
+
+		if victim == nil || last.Before(victimLast) {
+			victim, victimLast = c, last
+		}
+	}
+
+	if victim == nil {
+		return
+	}
+
+	e.logger.Debug("evicting connection for a protected peer", zap.Stringer("peer", victim.RemotePeer()), zap.Time("last activity", victimLast))
+	if err := victim.Close(); err != nil {
+		e.logger.Debug("unable to close evicted connection", zap.Error(err))
+	}
+	e.metrics.connsEvicted.Inc()
+}
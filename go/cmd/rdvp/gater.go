@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// handshakeTimeout is how long an inbound handshake holds its slot. The
+// libp2p upgrader has no hook on a failed upgrade, so a pending handshake
+// older than that is assumed failed: noise and TLS handshakes take a few
+// round trips, far below the 15s accept timeout of the upgrader.
+const handshakeTimeout = 5 * time.Second
+
+// connGater enforces the connection level limits of the node.
+type connGater struct {
+	logger  *zap.Logger
+	metrics *rdvpMetrics
+
+	// maxHandshakes limits the number of concurrent inbound security
+	// handshakes, 0 means no limit.
+	maxHandshakes int
+
+	muHandshakes sync.Mutex
+	handshakes   map[string]time.Time // remote addr -> accepted at
+
+	// maxConns is a hard limit of open connections, unlike the connection
+	// manager watermarks, the inbound connections above it are rejected.
+	// 0 means no limit.
+	maxConns int
+	// conns counts the open connections, see notifiee
+	conns atomic.Int64
+}
+
+func newConnGater(logger *zap.Logger, metrics *rdvpMetrics, maxHandshakes, maxConns int) *connGater {
+	return &connGater{
+		logger:        logger,
+		metrics:       metrics,
+		maxHandshakes: maxHandshakes,
+		handshakes:    make(map[string]time.Time),
+		maxConns:      maxConns,
+	}
+}
+
+// notifiee keeps the count of open connections of the network it is
+// registered on, the gater itself doesn't see the connections close.
+func (g *connGater) notifiee() libp2p_network.Notifiee {
+	return &libp2p_network.NotifyBundle{
+		ConnectedF:    func(libp2p_network.Network, libp2p_network.Conn) { g.conns.Add(1) },
+		DisconnectedF: func(libp2p_network.Network, libp2p_network.Conn) { g.conns.Add(-1) },
+	}
+}
+
+// connsFull reports whether the open connections reached maxConns. The
+// connections become open once upgraded, so the ones still in their
+// handshake can exceed it, checking again in InterceptSecured keeps that
+// to the handshakes in flight.
+func (g *connGater) connsFull() bool {
+	if g.maxConns <= 0 || g.conns.Load() < int64(g.maxConns) {
+		return false
+	}
+
+	g.metrics.connsRejected.WithLabelValues("max_connections").Inc()
+	g.logger.Warn("too many connections, connection rejected", zap.Int("max", g.maxConns))
+	return true
+}
+
+func (g *connGater) InterceptPeerDial(libp2p_peer.ID) bool { return true }
+
+func (g *connGater) InterceptAddrDial(libp2p_peer.ID, ma.Multiaddr) bool { return true }
+
+// InterceptAccept starts tracking the inbound handshake, and rejects it if
+// too many are already in progress.
+func (g *connGater) InterceptAccept(addrs libp2p_network.ConnMultiaddrs) bool {
+	if g.connsFull() {
+		return false
+	}
+
+	if g.maxHandshakes <= 0 {
+		return true
+	}
+
+	now := time.Now()
+
+	g.muHandshakes.Lock()
+	defer g.muHandshakes.Unlock()
+
+	if len(g.handshakes) >= g.maxHandshakes {
+		for remote, acceptedAt := range g.handshakes {
+			if now.Sub(acceptedAt) > handshakeTimeout {
+				delete(g.handshakes, remote)
+			}
+		}
+	}
+
+	if len(g.handshakes) >= g.maxHandshakes {
+		g.metrics.connsRejected.WithLabelValues("max_handshakes").Inc()
+		g.logger.Warn("too many concurrent handshakes, connection rejected", zap.Int("max", g.maxHandshakes))
+		return false
+	}
+
+	g.handshakes[addrs.RemoteMultiaddr().String()] = now
+	return true
+}
+
+// InterceptSecured is called once the security handshake is done.
+func (g *connGater) InterceptSecured(dir libp2p_network.Direction, _ libp2p_peer.ID, addrs libp2p_network.ConnMultiaddrs) bool {
+	if dir == libp2p_network.DirInbound && g.maxHandshakes > 0 {
+		g.muHandshakes.Lock()
+		delete(g.handshakes, addrs.RemoteMultiaddr().String())
+		g.muHandshakes.Unlock()
+	}
+
+	if dir == libp2p_network.DirInbound && g.connsFull() {
+		return false
+	}
+
+	return true
+}
+
+func (g *connGater) InterceptUpgraded(libp2p_network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
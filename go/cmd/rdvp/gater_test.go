@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type testConnAddrs struct{}
+
+type testRemoteAddrs string
+
+func (testRemoteAddrs) LocalMultiaddr() ma.Multiaddr    { return ma.StringCast("/ip4/127.0.0.1/tcp/4040") }
+func (a testRemoteAddrs) RemoteMultiaddr() ma.Multiaddr { return ma.StringCast(string(a)) }
+
+func (testConnAddrs) LocalMultiaddr() ma.Multiaddr  { return ma.StringCast("/ip4/127.0.0.1/tcp/4040") }
+func (testConnAddrs) RemoteMultiaddr() ma.Multiaddr { return ma.StringCast("/ip4/1.2.3.4/tcp/1234") }
+
+func TestConnGaterMaxConnections(t *testing.T) {
+	metrics := newRdvpMetrics()
+	g := newConnGater(zap.NewNop(), metrics, 0, 2)
+	n := g.notifiee()
+
+	assert.True(t, g.InterceptAccept(testConnAddrs{}))
+	n.Connected(nil, nil)
+	n.Connected(nil, nil)
+
+	assert.False(t, g.InterceptAccept(testConnAddrs{}))
+	assert.False(t, g.InterceptSecured(libp2p_network.DirInbound, "", testConnAddrs{}))
+	// only the inbound connections are limited
+	assert.True(t, g.InterceptSecured(libp2p_network.DirOutbound, "", testConnAddrs{}))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.connsRejected.WithLabelValues("max_connections")))
+
+	n.Disconnected(nil, nil)
+	assert.True(t, g.InterceptAccept(testConnAddrs{}))
+}
+
+func TestConnGaterMaxHandshakes(t *testing.T) {
+	metrics := newRdvpMetrics()
+	g := newConnGater(zap.NewNop(), metrics, 1, 0)
+
+	first := testRemoteAddrs("/ip4/1.2.3.4/tcp/1234")
+	second := testRemoteAddrs("/ip4/1.2.3.4/tcp/1235")
+	third := testRemoteAddrs("/ip4/1.2.3.4/tcp/1236")
+
+	assert.True(t, g.InterceptAccept(first))
+	assert.False(t, g.InterceptAccept(second))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.connsRejected.WithLabelValues("max_handshakes")))
+
+	// a secured handshake releases its slot
+	assert.True(t, g.InterceptSecured(libp2p_network.DirInbound, "", first))
+	assert.True(t, g.InterceptAccept(second))
+	assert.False(t, g.InterceptAccept(third))
+
+	// a failed handshake is never secured, its slot is released after
+	// handshakeTimeout
+	g.muHandshakes.Lock()
+	g.handshakes[second.RemoteMultiaddr().String()] = time.Now().Add(-handshakeTimeout - time.Second)
+	g.muHandshakes.Unlock()
+	assert.True(t, g.InterceptAccept(third))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.connsRejected.WithLabelValues("max_handshakes")))
+
+	// outbound handshakes don't use slots
+	assert.True(t, g.InterceptSecured(libp2p_network.DirOutbound, "", second))
+	assert.False(t, g.InterceptAccept(first))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// nolint:staticcheck
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p "github.com/libp2p/go-libp2p"
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// healthCheckNamespace is used by the deep health check and the monitor
+	// command. The service exempts the self-tests of its own deep health
+	// check from the namespace policies, other peers (ie. a remote monitor)
+	// need it to be allowed.
+	healthCheckNamespace = "rdvp/healthcheck"
+
+	deepHealthCheckInterval = 30 * time.Second
+	deepHealthCheckTimeout  = 10 * time.Second
+)
+
+// healthChecker reports the node health, if enabled, the deep check runs a
+// rendezvous self-test at most once per deepHealthCheckInterval and caches
+// its result in between.
+type healthChecker struct {
+	host   libp2p_host.Host
+	client libp2p_host.Host // nil if deep checks are disabled
+
+	// ready is set once the db is open and the rendezvous service is
+	// registered on the host
+	ready atomic.Bool
+
+	muDeep   sync.Mutex
+	lastDeep time.Time
+	errDeep  error
+}
+
+func newHealthChecker(host libp2p_host.Host, deep bool) (*healthChecker, error) {
+	h := &healthChecker{host: host}
+	if !deep {
+		return h, nil
+	}
+
+	client, err := libp2p.New(
+		libp2p.DisableRelay(),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create health check client: %w", err)
+	}
+
+	h.client = client
+	return h, nil
+}
+
+// clientID returns the peer ID of the deep check client, empty if the deep
+// checks are disabled.
+func (h *healthChecker) clientID() libp2p_peer.ID {
+	if h.client == nil {
+		return ""
+	}
+	return h.client.ID()
+}
+
+func (h *healthChecker) Close() error {
+	if h.client != nil {
+		return h.client.Close()
+	}
+	return nil
+}
+
+func (h *healthChecker) check() error {
+	if len(h.host.Network().ListenAddresses()) == 0 {
+		return errors.New("host is not listening")
+	}
+
+	if h.client == nil {
+		return nil
+	}
+
+	h.muDeep.Lock()
+	defer h.muDeep.Unlock()
+
+	if time.Since(h.lastDeep) < deepHealthCheckInterval {
+		return h.errDeep
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deepHealthCheckTimeout)
+	defer cancel()
+
+	h.errDeep = h.selfTest(ctx)
+	h.lastDeep = time.Now()
+	return h.errDeep
+}
+
+// selfTest registers then discovers healthCheckNamespace on the local
+// rendezvous service, through the client host.
+func (h *healthChecker) selfTest(ctx context.Context) error {
+	addrs, err := h.host.Network().InterfaceListenAddresses()
+	if err != nil {
+		return fmt.Errorf("unable to get listen addrs: %w", err)
+	}
+
+	return rendezvousSelfTest(ctx, h.client, libp2p_peer.AddrInfo{ID: h.host.ID(), Addrs: addrs})
+}
+
+// rendezvousSelfTest registers client on healthCheckNamespace of the
+// rendezvous point at target, then checks that discovery returns it.
+func rendezvousSelfTest(ctx context.Context, client libp2p_host.Host, target libp2p_peer.AddrInfo) error {
+	if err := client.Connect(ctx, target); err != nil {
+		return fmt.Errorf("unable to connect: %w", err)
+	}
+
+	rp := libp2p_rp.NewRendezvousPoint(client, target.ID)
+	if _, err := rp.Register(ctx, healthCheckNamespace, libp2p_rp.DefaultTTL); err != nil {
+		return fmt.Errorf("unable to register: %w", err)
+	}
+	defer rp.Unregister(ctx, healthCheckNamespace) // nolint:errcheck
+
+	regs, _, err := rp.Discover(ctx, healthCheckNamespace, 0, nil)
+	if err != nil {
+		return fmt.Errorf("unable to discover: %w", err)
+	}
+
+	for _, reg := range regs {
+		if reg.Peer.ID == client.ID() {
+			return nil
+		}
+	}
+
+	return errors.New("registration not found by discovery")
+}
+
+func (h *healthChecker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if err := h.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// markReady makes the readiness probe succeed.
+func (h *healthChecker) markReady() {
+	h.ready.Store(true)
+}
+
+// readyHandler is the readiness probe: it succeeds once markReady has been
+// called and as long as the liveness check does.
+func (h *healthChecker) readyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "rendezvous service not registered yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	libp2p "github.com/libp2p/go-libp2p"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthReadiness(t *testing.T) {
+	host, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer host.Close()
+
+	health, err := newHealthChecker(host, false)
+	require.NoError(t, err)
+	defer health.Close()
+
+	probe := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, probe(health))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(health.readyHandler()))
+
+	health.markReady()
+	assert.Equal(t, http.StatusOK, probe(health.readyHandler()))
+
+	// not ready anymore once the host stops listening
+	require.NoError(t, host.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, probe(health))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(health.readyHandler()))
+}
+
+// countingSync counts the registrations forwarded to a sync driver.
+type countingSync struct {
+	registers, unregisters atomic.Int32
+}
+
+func (s *countingSync) Register(libp2p_peer.ID, string, [][]byte, int, uint64) { s.registers.Add(1) }
+func (s *countingSync) Unregister(libp2p_peer.ID, string)                      { s.unregisters.Add(1) }
+
+func TestSelfTestExemptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	shards, err := parseShardMap(strings.NewReader("rdvp/* " + testShardOwner))
+	require.NoError(t, err)
+
+	// policies all excluding healthCheckNamespace
+	metrics := newRdvpMetrics()
+	client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	sync := &countingSync{}
+	_ = newService(server, db, serviceOptions{
+		Metrics:             metrics,
+		NamespaceAllowlist:  namespaceAllowlist{"ns"},
+		NamespacePattern:    regexp.MustCompile(`^[a-z]+$`),
+		MaxNamespaceLength:  8,
+		ShardMap:            shards,
+		NewNamespaceLimiter: newPeerRateLimiter(1, peerRateLimiterPeers),
+		HealthCheckPeer:     client.ID(),
+	}, sync)
+
+	// uses the only new namespace of the minute
+	require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+	_, err = libp2p_rp.NewRendezvousPoint(client, server.ID()).Register(ctx, "ns", 60)
+	require.NoError(t, err)
+
+	require.NoError(t, rendezvousSelfTest(ctx, client, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+
+	// only the real registration is accounted and forwarded
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationsAccepted))
+	assert.Equal(t, int32(1), sync.registers.Load())
+	assert.Never(t, func() bool { return sync.unregisters.Load() > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	// the exemption is only for the health check client of the node
+	other, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer other.Close()
+
+	assert.Error(t, rendezvousSelfTest(ctx, other, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+	_, _, err = libp2p_rp.NewRendezvousPoint(other, server.ID()).Discover(ctx, healthCheckNamespace, 0, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyNamespace))))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"go.uber.org/zap"
+)
+
+// watchIdentify closes the connections whose identify exchange did not
+// complete within timeout.
+func watchIdentify(logger *zap.Logger, metrics *rdvpMetrics, host libp2p_host.Host, timeout time.Duration) error {
+	idh, ok := host.(interface{ IDService() identify.IDService })
+	if !ok {
+		return fmt.Errorf("host has no identify service")
+	}
+	ids := idh.IDService()
+
+	host.Network().Notify(&libp2p_network.NotifyBundle{
+		ConnectedF: func(_ libp2p_network.Network, c libp2p_network.Conn) {
+			go func() {
+				timer := time.NewTimer(timeout)
+				defer timer.Stop()
+
+				select {
+				case <-ids.IdentifyWait(c):
+					return
+				case <-timer.C:
+				}
+
+				if c.IsClosed() {
+					return
+				}
+
+				metrics.identifyTimeouts.Inc()
+				logger.Debug("closing connection not identified in time", zap.Stringer("peer", c.RemotePeer()), zap.Stringer("addr", c.RemoteMultiaddr()))
+				if err := c.Close(); err != nil {
+					logger.Debug("unable to close connection", zap.Error(err))
+				}
+			}()
+		},
+	})
+
+	return nil
+}
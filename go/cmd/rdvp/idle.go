@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	"go.uber.org/zap"
+)
+
+// minPeerIdleTimeout is the shortest -peer-idle-timeout, shorter ones would
+// close connections between two requests of a client.
+const minPeerIdleTimeout = 10 * time.Second
+
+// validatePeerIdleTimeout checks -peer-idle-timeout, 0 disables the tracker.
+func validatePeerIdleTimeout(timeout time.Duration) error {
+	if timeout != 0 && timeout < minPeerIdleTimeout {
+		return fmt.Errorf("-peer-idle-timeout must be 0 or at least %s, got %s", minPeerIdleTimeout, timeout)
+	}
+	return nil
+}
+
+// idleTracker closes the connections on which no rendezvous activity
+// happened for longer than timeout. Connections never used for
+// rendezvous (relay only peers, ...) are left untouched.
+type idleTracker struct {
+	logger  *zap.Logger
+	metrics *rdvpMetrics
+	timeout time.Duration
+
+	muActivity sync.Mutex
+	activity   map[libp2p_network.Conn]time.Time
+}
+
+func newIdleTracker(logger *zap.Logger, metrics *rdvpMetrics, host libp2p_host.Host, timeout time.Duration) *idleTracker {
+	t := &idleTracker{
+		logger:   logger,
+		metrics:  metrics,
+		timeout:  timeout,
+		activity: make(map[libp2p_network.Conn]time.Time),
+	}
+
+	host.Network().Notify(&libp2p_network.NotifyBundle{
+		DisconnectedF: func(_ libp2p_network.Network, c libp2p_network.Conn) {
+			t.muActivity.Lock()
+			delete(t.activity, c)
+			t.muActivity.Unlock()
+		},
+	})
+
+	return t
+}
+
+// touch marks c as active, it's a no-op on a nil tracker.
+func (t *idleTracker) touch(c libp2p_network.Conn) {
+	if t == nil {
+		return
+	}
+
+	t.muActivity.Lock()
+	t.activity[c] = time.Now()
+	t.muActivity.Unlock()
+}
+
+// lastActivity returns the last rendezvous activity seen on c, if any.
+func (t *idleTracker) lastActivity(c libp2p_network.Conn) (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+
+	t.muActivity.Lock()
+	last, ok := t.activity[c]
+	t.muActivity.Unlock()
+	return last, ok
+}
+
+// run closes idle connections until ctx is done.
+func (t *idleTracker) run(ctx context.Context) error {
+	ticker := time.NewTicker(t.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		var idles []libp2p_network.Conn
+		deadline := time.Now().Add(-t.timeout)
+
+		t.muActivity.Lock()
+		for c, last := range t.activity {
+			// touch can race with DisconnectedF and re-insert a closed conn
+			if c.IsClosed() {
+				delete(t.activity, c)
+				continue
+			}
+
+			if last.Before(deadline) {
+				idles = append(idles, c)
+				delete(t.activity, c)
+			}
+		}
+		t.muActivity.Unlock()
+
+		for _, c := range idles {
+			t.logger.Debug("closing idle connection", zap.Stringer("peer", c.RemotePeer()), zap.Stringer("addr", c.RemoteMultiaddr()))
+			if err := c.Close(); err != nil {
+				t.logger.Debug("unable to close idle connection", zap.Error(err))
+			}
+			t.metrics.idleConnsClosed.Inc()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestValidatePeerIdleTimeout(t *testing.T) {
+	assert.NoError(t, validatePeerIdleTimeout(0))
+	assert.NoError(t, validatePeerIdleTimeout(minPeerIdleTimeout))
+	assert.Error(t, validatePeerIdleTimeout(time.Nanosecond))
+	assert.Error(t, validatePeerIdleTimeout(-time.Minute))
+}
+
+func TestIdleTracker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	newClient := func() libp2p_peer.ID {
+		client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+		require.Eventually(t, func() bool {
+			return len(server.Network().ConnsToPeer(client.ID())) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		return client.ID()
+	}
+
+	metrics := newRdvpMetrics()
+	// below minPeerIdleTimeout to keep the test short
+	idle := newIdleTracker(zap.NewNop(), metrics, server, 100*time.Millisecond)
+
+	idler := newClient()
+	idle.touch(server.Network().ConnsToPeer(idler)[0])
+
+	// a conn touched after DisconnectedF ran is dropped, not closed again
+	gone := newClient()
+	goneConn := server.Network().ConnsToPeer(gone)[0]
+	idle.touch(goneConn)
+	require.NoError(t, goneConn.Close())
+	require.Eventually(t, func() bool {
+		_, tracked := idle.lastActivity(goneConn)
+		return !tracked
+	}, 5*time.Second, 10*time.Millisecond)
+	idle.touch(goneConn)
+
+	go func() { _ = idle.run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return len(server.Network().ConnsToPeer(idler)) == 0 && testutil.ToFloat64(metrics.idleConnsClosed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, tracked := idle.lastActivity(goneConn)
+	assert.False(t, tracked)
+
+	// a few more rounds, only the idle conn was closed
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.idleConnsClosed))
+}
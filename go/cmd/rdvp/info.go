@@ -0,0 +1,37 @@
+package main
+
+import (
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+)
+
+// serverInfo describes the running server for the orchestration tooling,
+// see -info-file.
+type serverInfo struct {
+	PeerID          string   `json:"peer_id"`
+	ListenAddrs     []string `json:"listen_addrs"`
+	AnnouncedAddrs  []string `json:"announced_addrs"`
+	EmitterEnabled  bool     `json:"emitter_enabled"`
+	MetricsEnabled  bool     `json:"metrics_enabled"`
+	MetricsListener string   `json:"metrics_listener,omitempty"`
+}
+
+func newServerInfo(host libp2p_host.Host, emitter bool, metricsListener string) *serverInfo {
+	info := &serverInfo{
+		PeerID:          host.ID().String(),
+		ListenAddrs:     []string{},
+		AnnouncedAddrs:  []string{},
+		EmitterEnabled:  emitter,
+		MetricsEnabled:  metricsListener != "",
+		MetricsListener: metricsListener,
+	}
+
+	for _, addr := range host.Network().ListenAddresses() {
+		info.ListenAddrs = append(info.ListenAddrs, addr.String())
+	}
+
+	for _, addr := range host.Addrs() {
+		info.AnnouncedAddrs = append(info.AnnouncedAddrs, addr.String())
+	}
+
+	return info
+}
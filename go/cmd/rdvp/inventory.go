@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+
+	"berty.tech/berty/v2/go/pkg/bertyversion"
+)
+
+const inventoryPostTimeout = 10 * time.Second
+
+// nodeInventory is the machine-readable record of a node, written at
+// startup for the asset inventory.
+type nodeInventory struct {
+	PeerID       string            `json:"peer_id"`
+	Version      string            `json:"version"`
+	VcsRef       string            `json:"vcs_ref"`
+	DeploymentID string            `json:"deployment_id"`
+	StartedAt    time.Time         `json:"started_at"`
+	Addrs        []string          `json:"addrs"`
+	Services     []string          `json:"services"`
+	DBBackend    string            `json:"db_backend"`
+	Config       map[string]string `json:"config"`
+}
+
+func newNodeInventory(host libp2p_host.Host, fs *flag.FlagSet, deploymentID string, dbURN string, services []string) *nodeInventory {
+	inv := &nodeInventory{
+		PeerID:       host.ID().String(),
+		Version:      bertyversion.Version,
+		VcsRef:       bertyversion.VcsRef,
+		DeploymentID: deploymentID,
+		StartedAt:    time.Now().UTC(),
+		Services:     services,
+		DBBackend:    "sqlcipher (file)",
+		Config:       redactedConfig(fs),
+	}
+
+	if dbURN == memoryDBURN {
+		inv.DBBackend = "sqlcipher (memory)"
+	}
+
+	for _, addr := range host.Addrs() {
+		inv.Addrs = append(inv.Addrs, addr.String())
+	}
+
+	return inv
+}
+
+// writeFile writes the inventory to path atomically, see writeJSONFile.
+func (inv *nodeInventory) writeFile(path string) error {
+	return writeJSONFile(path, inv)
+}
+
+// post sends the inventory to url as a JSON body.
+func (inv *nodeInventory) post(ctx context.Context, url string) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, inventoryPostTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// writeJSONFile writes v as JSON to path atomically, through a temporary
+// file renamed over path: readers see either the previous file or the
+// complete new one.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// autosaveKeyFile is the name of the key file written by -pk-autosave, next
+// to the database.
+const autosaveKeyFile = "rdvp.key"
+
+// decodePrivateKey decodes a private key as printed by `rdvp genkey`.
+func decodePrivateKey(pk string) (libp2p_ci.PrivKey, error) {
+	kbytes, err := base64.StdEncoding.DecodeString(pk)
+	if err != nil {
+		return nil, err
+	}
+	return libp2p_ci.UnmarshalPrivateKey(kbytes)
+}
+
+// readPrivateKeyFile returns the encoded private key stored in path by
+// `rdvp genkey -output`.
+func readPrivateKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// keys written by `rdvp genkey > file` end with a newline
+	return strings.TrimSpace(string(data)), nil
+}
+
+// autosaveKeyPath returns the path of the key saved by -pk-autosave for the
+// database at dbURN.
+func autosaveKeyPath(dbURN string) (string, error) {
+	if dbURN == memoryDBURN {
+		return "", fmt.Errorf("-pk-autosave requires an on-disk -db, the key is saved next to it")
+	}
+	return filepath.Join(filepath.Dir(dbURN), autosaveKeyFile), nil
+}
+
+// loadKey loads the private key stored in path, the error wraps
+// fs.ErrNotExist if the file doesn't exist.
+func loadKey(path string) (libp2p_ci.PrivKey, error) {
+	pk, err := readPrivateKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := decodePrivateKey(pk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in `%s`: %w", path, err)
+	}
+	return priv, nil
+}
+
+// loadOrCreateKey loads the private key stored in path, or generates an
+// Ed25519 key and stores it there (mode 0600) if the file doesn't exist.
+func loadOrCreateKey(path string) (priv libp2p_ci.PrivKey, created bool, err error) {
+	priv, err = loadKey(path)
+	switch {
+	case err == nil:
+		return priv, false, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, false, err
+	}
+
+	priv, _, err = libp2p_ci.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	kbytes, err := libp2p_ci.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// O_EXCL: never overwrite a key written concurrently by another instance
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(kbytes) + "\n"); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, false, err
+	}
+
+	return priv, true, nil
+}
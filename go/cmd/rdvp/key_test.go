@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrCreateKey(t *testing.T) {
+	dir := t.TempDir()
+
+	path, err := autosaveKeyPath(filepath.Join(dir, "rdvp.db"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, autosaveKeyFile), path)
+
+	_, err = autosaveKeyPath(memoryDBURN)
+	require.Error(t, err)
+
+	priv, created, err := loadOrCreateKey(path)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	loaded, created, err := loadOrCreateKey(path)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.True(t, priv.Equals(loaded))
+
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+	_, _, err = loadOrCreateKey(path)
+	require.Error(t, err)
+}
+
+func TestLoadKeyDoesNotCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), autosaveKeyFile)
+
+	_, err := loadKey(path)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	priv, _, err := loadOrCreateKey(path)
+	require.NoError(t, err)
+
+	loaded, err := loadKey(path)
+	require.NoError(t, err)
+	require.True(t, priv.Equals(loaded))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// openDBReadOnly opens the rdvp sqlite db at path without write access.
+//
+// libp2p_rpdb.OpenDB is not usable for inspection: it creates the db if it
+// doesn't exist and deletes the expired registrations in the background.
+func openDBReadOnly(path string) (*sql.DB, error) {
+	if path == memoryDBURN {
+		return nil, fmt.Errorf("an in-memory database cannot be inspected")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", "file:"+path+"?mode=ro")
+}
+
+// listRegistrations returns the active registrations stored in db, on ns
+// only if not empty, ordered by namespace then peer.
+func listRegistrations(ctx context.Context, db *sql.DB, ns string) ([]snapshotRegistration, error) {
+	query := "SELECT peer, ns, expire, addrs FROM Registrations WHERE expire > ?"
+	args := []interface{}{time.Now().Unix()}
+	if ns != "" {
+		query += " AND ns = ?"
+		args = append(args, ns)
+	}
+
+	rows, err := db.QueryContext(ctx, query+" ORDER BY ns, peer", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	regs := []snapshotRegistration{}
+	for rows.Next() {
+		var (
+			rawPeer  string
+			reg      snapshotRegistration
+			rawAddrs []byte
+		)
+		if err := rows.Scan(&rawPeer, &reg.Namespace, &reg.Expire, &rawAddrs); err != nil {
+			return nil, err
+		}
+
+		p, err := libp2p_peer.Decode(rawPeer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer `%s`: %w", rawPeer, err)
+		}
+		reg.Peer = p.String()
+
+		addrs, err := unpackRegistrationAddrs(rawAddrs)
+		if err != nil {
+			return nil, fmt.Errorf("registration of %s on `%s`: %w", reg.Peer, reg.Namespace, err)
+		}
+		for _, addr := range addrs {
+			reg.Addrs = append(reg.Addrs, addr.String())
+		}
+
+		regs = append(regs, reg)
+	}
+
+	return regs, rows.Err()
+}
+
+// unpackRegistrationAddrs decodes the addrs column of the Registrations
+// table, each address is prefixed by its big endian uint16 length (see
+// packAddrs in go-libp2p-rendezvous/db/sqlcipher).
+func unpackRegistrationAddrs(packed []byte) ([]ma.Multiaddr, error) {
+	var addrs []ma.Multiaddr
+	for buf := packed; len(buf) > 0; {
+		if len(buf) < 2 {
+			return nil, fmt.Errorf("bad packed addresses: %d unprocessed bytes", len(buf))
+		}
+
+		l := int(binary.BigEndian.Uint16(buf))
+		buf = buf[2:]
+		if len(buf) < l {
+			return nil, fmt.Errorf("bad packed addresses: want %d bytes, got %d", l, len(buf))
+		}
+
+		addr, err := ma.NewMultiaddrBytes(buf[:l])
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+		buf = buf[l:]
+	}
+
+	return addrs, nil
+}
+
+// printRegistrations writes regs grouped by namespace, with the time left
+// before their expiration at now.
+func printRegistrations(w io.Writer, regs []snapshotRegistration, now time.Time) {
+	sort.SliceStable(regs, func(i, j int) bool { return regs[i].Namespace < regs[j].Namespace })
+
+	for i, reg := range regs {
+		if i == 0 || regs[i-1].Namespace != reg.Namespace {
+			fmt.Fprintf(w, "%s\n", reg.Namespace)
+		}
+
+		expire := time.Unix(reg.Expire, 0)
+		fmt.Fprintf(w, "  %s expires %s (in %s)\n", reg.Peer,
+			expire.UTC().Format(time.RFC3339), expire.Sub(now).Truncate(time.Second))
+		if len(reg.Addrs) > 0 {
+			fmt.Fprintf(w, "    %s\n", strings.Join(reg.Addrs, "\n    "))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListRegistrations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "rdvp.db")
+
+	_, err := openDBReadOnly(path)
+	require.Error(t, err)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err), "the db should not be created")
+
+	rdb, err := libp2p_rpdb.OpenDB(ctx, path)
+	require.NoError(t, err)
+
+	newPeer := func() libp2p_peer.ID {
+		priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+		p, err := libp2p_peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		return p
+	}
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4040")
+	p1, p2 := newPeer(), newPeer()
+	_, err = rdb.Register(p1, "ns1", [][]byte{addr.Bytes()}, 60)
+	require.NoError(t, err)
+	_, err = rdb.Register(p2, "ns2", nil, 120)
+	require.NoError(t, err)
+	require.NoError(t, rdb.Close())
+
+	db, err := openDBReadOnly(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	regs, err := listRegistrations(ctx, db, "")
+	require.NoError(t, err)
+	require.Len(t, regs, 2)
+	assert.Equal(t, p1.String(), regs[0].Peer)
+	assert.Equal(t, "ns1", regs[0].Namespace)
+	assert.Equal(t, []string{addr.String()}, regs[0].Addrs)
+	assert.Equal(t, p2.String(), regs[1].Peer)
+	assert.Empty(t, regs[1].Addrs)
+
+	regs, err = listRegistrations(ctx, db, "ns2")
+	require.NoError(t, err)
+	require.Len(t, regs, 1)
+	assert.Equal(t, p2.String(), regs[0].Peer)
+
+	_, err = db.ExecContext(ctx, "DELETE FROM Registrations")
+	assert.Error(t, err, "the db should be read-only")
+
+	var out bytes.Buffer
+	printRegistrations(&out, regs, time.Unix(regs[0].Expire-120, 0))
+	assert.Contains(t, out.String(), "ns2\n  "+p2.String()+" expires ")
+	assert.Contains(t, out.String(), "(in 2m0s)")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// normalizeListeners checks that the transport of each listener can be
+// served by the host, and adds a quic-v1 listener on the port of each
+// legacy quic (draft-29) one, so both versions are served:
+// `/ip4/0.0.0.0/udp/4141/quic` also listens on
+// `/ip4/0.0.0.0/udp/4141/quic-v1`.
+func normalizeListeners(listeners []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	var (
+		out  []ma.Multiaddr
+		seen = make(map[string]bool)
+	)
+	add := func(m ma.Multiaddr) {
+		if key := string(m.Bytes()); !seen[key] {
+			seen[key] = true
+			out = append(out, m)
+		}
+	}
+
+	for _, l := range listeners {
+		_, isQUIC := hasProtocol(l, ma.P_QUIC)
+		_, isQUICv1 := hasProtocol(l, ma.P_QUIC_V1)
+
+		if _, isWebTransport := hasProtocol(l, ma.P_WEBTRANSPORT); isWebTransport {
+			if !isQUICv1 {
+				return nil, fmt.Errorf("invalid listener `%s`: webtransport runs over quic-v1 (ie. /ip4/0.0.0.0/udp/4141/quic-v1/webtransport)", l)
+			}
+			if _, hasCerthash := hasProtocol(l, ma.P_CERTHASH); hasCerthash {
+				return nil, fmt.Errorf("invalid listener `%s`: the certificate hashes are generated by the host", l)
+			}
+		}
+
+		add(l)
+		if isQUIC {
+			add(quicV1Listener(l))
+		}
+	}
+
+	return out, nil
+}
+
+func hasProtocol(m ma.Multiaddr, code int) (string, bool) {
+	value, err := m.ValueForProtocol(code)
+	return value, err == nil
+}
+
+// quicV1Listener returns m with its quic component replaced by quic-v1.
+func quicV1Listener(m ma.Multiaddr) ma.Multiaddr {
+	var components []ma.Multiaddr
+	ma.ForEach(m, func(c ma.Component) bool {
+		if c.Protocol().Code == ma.P_QUIC {
+			components = append(components, ma.StringCast("/quic-v1"))
+		} else {
+			components = append(components, &c)
+		}
+		return true
+	})
+	return ma.Join(components...)
+}
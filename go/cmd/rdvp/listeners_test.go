@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNormalizeListeners(t *testing.T) {
+	parse := func(addrs ...string) []ma.Multiaddr {
+		var ms []ma.Multiaddr
+		for _, addr := range addrs {
+			ms = append(ms, ma.StringCast(addr))
+		}
+		return ms
+	}
+
+	listeners, err := normalizeListeners(parse(
+		"/ip4/127.0.0.1/tcp/0",
+		"/ip4/127.0.0.1/udp/0/quic",
+		"/ip4/127.0.0.1/udp/0/quic-v1",
+		"/ip4/127.0.0.1/udp/0/quic-v1/webtransport",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, parse(
+		"/ip4/127.0.0.1/tcp/0",
+		"/ip4/127.0.0.1/udp/0/quic",
+		"/ip4/127.0.0.1/udp/0/quic-v1",
+		"/ip4/127.0.0.1/udp/0/quic-v1/webtransport",
+	), listeners)
+
+	_, err = normalizeListeners(parse("/ip4/127.0.0.1/udp/0/quic/webtransport"))
+	assert.Error(t, err)
+
+	// every normalized listener can be bound by the host
+	host, err := libp2p.New(libp2p.DefaultTransports, libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer host.Close()
+
+	listeners, err = normalizeListeners(parse("/ip4/127.0.0.1/udp/0/quic", "/ip4/127.0.0.1/udp/0/quic-v1/webtransport"))
+	require.NoError(t, err)
+	require.NoError(t, listen(zap.NewNop(), host, listeners, false))
+	assert.Len(t, host.Network().ListenAddresses(), 3)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"berty.tech/weshnet/pkg/logutil"
+)
+
+// logFormatLogfmt is the -log.format producing `key=value` lines, the
+// other formats are handled by logutil.
+const logFormatLogfmt = "logfmt"
+
+// newLogStream returns the log stream for the global log flags.
+func newLogStream(filters, format, path string) logutil.Stream {
+	// -log.file always logs JSON
+	if format != logFormatLogfmt || path != "" {
+		return logutil.NewStdStream(filters, format, path)
+	}
+
+	core := zapcore.NewCore(newLogfmtEncoder(), zapcore.Lock(os.Stderr), zapcore.DebugLevel)
+	return logutil.NewCustomStream(filters, zap.New(core, zap.AddCaller()))
+}
+
+// sampledLogger samples the entries of logger: each second, the first
+// initial entries with the same level and message are logged, then one
+// every thereafter.
+func sampledLogger(logger *zap.Logger, initial, thereafter int) (*zap.Logger, error) {
+	if initial < 0 || thereafter < 1 {
+		return nil, fmt.Errorf("invalid log sampling: initial must be positive and thereafter at least 1")
+	}
+
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, time.Second, initial, thereafter)
+	})), nil
+}
+
+var logfmtBufferPool = buffer.NewPool()
+
+// logfmtEncoder is a zapcore.Encoder writing the entries as logfmt lines:
+// ts, level, logger, caller and msg, then the fields sorted by key. Nested
+// objects and arrays are written as JSON.
+type logfmtEncoder struct {
+	*zapcore.MapObjectEncoder
+}
+
+func newLogfmtEncoder() zapcore.Encoder {
+	return &logfmtEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder()}
+}
+
+func (e *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := zapcore.NewMapObjectEncoder()
+	for k, v := range e.Fields {
+		clone.Fields[k] = v
+	}
+	return &logfmtEncoder{MapObjectEncoder: clone}
+}
+
+func (e *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	enc := e.Clone().(*logfmtEncoder)
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	buf := logfmtBufferPool.Get()
+	appendLogfmt(buf, "ts", ent.Time.UTC().Format(time.RFC3339Nano))
+	appendLogfmt(buf, "level", ent.Level.String())
+	if ent.LoggerName != "" {
+		appendLogfmt(buf, "logger", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		appendLogfmt(buf, "caller", ent.Caller.TrimmedPath())
+	}
+	appendLogfmt(buf, "msg", ent.Message)
+
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		appendLogfmt(buf, k, logfmtValue(enc.Fields[k]))
+	}
+	if ent.Stack != "" {
+		appendLogfmt(buf, "stacktrace", ent.Stack)
+	}
+
+	buf.AppendByte('\n')
+	return buf, nil
+}
+
+func logfmtValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func appendLogfmt(buf *buffer.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.AppendByte(' ')
+	}
+
+	buf.AppendString(logfmtKey(key))
+	buf.AppendByte('=')
+	if logfmtNeedsQuote(value) {
+		buf.AppendString(fmt.Sprintf("%q", value))
+	} else {
+		buf.AppendString(value)
+	}
+}
+
+// logfmtKey replaces the characters not allowed in a key by `_`.
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func logfmtNeedsQuote(value string) bool {
+	if value == "" {
+		return true
+	}
+
+	return strings.IndexFunc(value, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar || !unicode.IsPrint(r)
+	}) >= 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogfmtEncoder(t *testing.T) {
+	enc := newLogfmtEncoder()
+	enc.AddString("deployment id", "eu-1")
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{
+		Level:      zapcore.InfoLevel,
+		Time:       time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		LoggerName: "rdvp",
+		Message:    "host started",
+	}, []zapcore.Field{
+		zap.Int("peers", 3),
+		zap.Duration("uptime", time.Minute),
+		zap.Error(errors.New(`dial "x": refused`)),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `ts=2023-01-02T03:04:05Z level=info logger=rdvp msg="host started" deployment_id=eu-1 error="dial \"x\": refused" peers=3 uptime=1m0s`+"\n", buf.String())
+}
+
+func TestSampledLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger, err := sampledLogger(zap.New(core), 2, 3)
+	require.NoError(t, err)
+
+	for i := 0; i < 8; i++ {
+		logger.Info("discover")
+	}
+	logger.Info("register")
+
+	// 2 first, then the 3rd of the next ones (5th overall), then the 8th
+	assert.Equal(t, 4, logs.FilterMessage("discover").Len())
+	assert.Equal(t, 1, logs.FilterMessage("register").Len())
+
+	_, err = sampledLogger(zap.NewNop(), 1, 0)
+	assert.Error(t, err)
+}
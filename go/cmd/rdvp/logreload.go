@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"berty.tech/weshnet/pkg/logutil"
+)
+
+// reloadableLogger builds the logger of the global log flags and can
+// rebuild it with new filters at runtime: logutil compiles the filters in
+// the core of the logger, so the loggers handed out write through a
+// coreSwitch and a reload swaps the core behind it.
+type reloadableLogger struct {
+	format, path string
+	sw           *coreSwitch
+
+	muReload sync.Mutex
+	filters  string
+	cleanup  func()
+}
+
+func newReloadableLogger(filters, format, path string) (*reloadableLogger, *zap.Logger, error) {
+	logger, cleanup, err := logutil.NewLogger(newLogStream(filters, format, path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sw := newCoreSwitch(logger.Core())
+	rl := &reloadableLogger{
+		format:  format,
+		path:    path,
+		sw:      sw,
+		filters: filters,
+		cleanup: cleanup,
+	}
+
+	return rl, logger.WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core { return sw.root() })), nil
+}
+
+// reload applies filters to the loggers, it returns the previous filters.
+// On error, the loggers are left untouched.
+func (rl *reloadableLogger) reload(filters string) (string, error) {
+	logger, cleanup, err := logutil.NewLogger(newLogStream(filters, rl.format, rl.path))
+	if err != nil {
+		return "", err
+	}
+
+	rl.muReload.Lock()
+	defer rl.muReload.Unlock()
+
+	rl.sw.swap(logger.Core())
+	rl.cleanup()
+
+	old := rl.filters
+	rl.filters, rl.cleanup = filters, cleanup
+	return old, nil
+}
+
+func (rl *reloadableLogger) close() {
+	rl.muReload.Lock()
+	defer rl.muReload.Unlock()
+	rl.cleanup()
+}
+
+// readLogFiltersFile returns the log filters stored in path.
+func readLogFiltersFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// reloadLogFiltersOnSIGHUP applies the filters of path to rl each time the
+// process receives a SIGHUP.
+func reloadLogFiltersOnSIGHUP(ctx context.Context, logger *zap.Logger, rl *reloadableLogger, path string) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		filters, err := readLogFiltersFile(path)
+		if err != nil {
+			logger.Warn("unable to read the log filters", zap.String("path", path), zap.Error(err))
+			continue
+		}
+
+		old, err := rl.reload(filters)
+		if err != nil {
+			logger.Warn("unable to apply the log filters", zap.String("filters", filters), zap.Error(err))
+			continue
+		}
+
+		logger.Info("log filters reloaded", zap.String("old", old), zap.String("new", filters))
+	}
+}
+
+// coreSwitch holds the core the switchCores write to.
+type coreSwitch struct {
+	current atomic.Pointer[switchedCore]
+}
+
+// switchedCore is a core of a coreSwitch generation.
+type switchedCore struct {
+	gen  uint64
+	core zapcore.Core
+}
+
+func newCoreSwitch(core zapcore.Core) *coreSwitch {
+	sw := &coreSwitch{}
+	sw.current.Store(&switchedCore{core: core})
+	return sw
+}
+
+func (sw *coreSwitch) root() zapcore.Core {
+	return &switchCore{sw: sw}
+}
+
+func (sw *coreSwitch) swap(core zapcore.Core) {
+	sw.current.Store(&switchedCore{gen: sw.current.Load().gen + 1, core: core})
+}
+
+// switchCore is a zapcore.Core writing to the current core of a coreSwitch,
+// with its own fields.
+type switchCore struct {
+	sw     *coreSwitch
+	fields []zapcore.Field
+
+	// the current core with fields, derived once per generation
+	derived atomic.Pointer[switchedCore]
+}
+
+func (c *switchCore) core() zapcore.Core {
+	current := c.sw.current.Load()
+	if len(c.fields) == 0 {
+		return current.core
+	}
+
+	if derived := c.derived.Load(); derived != nil && derived.gen == current.gen {
+		return derived.core
+	}
+
+	derived := &switchedCore{gen: current.gen, core: current.core.With(c.fields)}
+	c.derived.Store(derived)
+	return derived.core
+}
+
+func (c *switchCore) Enabled(lvl zapcore.Level) bool {
+	return c.core().Enabled(lvl)
+}
+
+func (c *switchCore) With(fields []zapcore.Field) zapcore.Core {
+	return &switchCore{
+		sw:     c.sw,
+		fields: append(c.fields[:len(c.fields):len(c.fields)], fields...),
+	}
+}
+
+func (c *switchCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core().Check(ent, ce)
+}
+
+func (c *switchCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(ent, fields)
+}
+
+func (c *switchCore) Sync() error {
+	return c.core().Sync()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCoreSwitch(t *testing.T) {
+	infoCore, infoLogs := observer.New(zapcore.InfoLevel)
+	sw := newCoreSwitch(infoCore)
+
+	logger := zap.New(sw.root())
+	child := logger.Named("child").With(zap.String("k", "v"))
+
+	child.Debug("filtered")
+	child.Info("logged")
+	assert.Equal(t, 1, infoLogs.Len())
+
+	// the loggers created before the swap use the new core, with their fields
+	debugCore, debugLogs := observer.New(zapcore.DebugLevel)
+	sw.swap(debugCore)
+
+	child.Debug("logged")
+	logger.Debug("logged")
+	assert.Equal(t, 1, infoLogs.Len())
+	if assert.Equal(t, 2, debugLogs.Len()) {
+		entry := debugLogs.All()[0]
+		assert.Equal(t, "child", entry.LoggerName)
+		assert.Equal(t, map[string]interface{}{"k": "v"}, entry.ContextMap())
+		assert.Empty(t, debugLogs.All()[1].Context)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// summaryCore wraps a zapcore.Core so entries at or above level are only
+// written once per interval for a given message, duplicates are counted
+// and reported by flush.
+type summaryCore struct {
+	zapcore.Core
+
+	level    zapcore.Level
+	interval time.Duration
+	onEntry  func(zapcore.Entry)
+	state    *summaryState
+}
+
+type summaryState struct {
+	muMessages sync.Mutex
+	messages   map[string]*summaryMessage
+}
+
+type summaryMessage struct {
+	core       zapcore.Core
+	entry      zapcore.Entry
+	lastLogged time.Time
+	suppressed int
+}
+
+// newSummaryCore returns a summaryCore, onEntry (if not nil) is called for
+// every entry at or above level, suppressed or not.
+func newSummaryCore(core zapcore.Core, level zapcore.Level, interval time.Duration, onEntry func(zapcore.Entry)) *summaryCore {
+	return &summaryCore{
+		Core:     core,
+		level:    level,
+		interval: interval,
+		onEntry:  onEntry,
+		state:    &summaryState{messages: make(map[string]*summaryMessage)},
+	}
+}
+
+func (c *summaryCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+	return &clone
+}
+
+func (c *summaryCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level < c.level {
+		return c.Core.Check(ent, ce)
+	}
+
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *summaryCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if c.onEntry != nil {
+		c.onEntry(ent)
+	}
+
+	c.state.muMessages.Lock()
+	msg, ok := c.state.messages[ent.Message]
+	if !ok {
+		msg = &summaryMessage{}
+		c.state.messages[ent.Message] = msg
+	}
+
+	if ok && ent.Time.Sub(msg.lastLogged) < c.interval {
+		msg.suppressed++
+		c.state.muMessages.Unlock()
+		return nil
+	}
+
+	suppressed := msg.suppressed
+	msg.core, msg.entry, msg.lastLogged, msg.suppressed = c.Core, ent, ent.Time, 0
+	c.state.muMessages.Unlock()
+
+	if suppressed > 0 {
+		fields = append(fields, zap.Int("suppressed", suppressed))
+	}
+	return c.Core.Write(ent, fields)
+}
+
+// flush writes a summary entry for each message with suppressed duplicates.
+func (c *summaryCore) flush() {
+	now := time.Now()
+
+	c.state.muMessages.Lock()
+	defer c.state.muMessages.Unlock()
+
+	for key, msg := range c.state.messages {
+		if msg.suppressed == 0 {
+			if now.Sub(msg.lastLogged) > c.interval {
+				delete(c.state.messages, key)
+			}
+			continue
+		}
+
+		ent := msg.entry
+		ent.Time = now
+		_ = msg.core.Write(ent, []zapcore.Field{
+			zap.Int("suppressed", msg.suppressed),
+			zap.Duration("interval", c.interval),
+		})
+
+		msg.lastLogged, msg.suppressed = now, 0
+	}
+}
+
+// run flushes the summaries every interval until ctx is done.
+func (c *summaryCore) run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-ctx.Done():
+			c.flush()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSummaryCore(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+
+	var seen int
+	summary := newSummaryCore(observed, zapcore.ErrorLevel, time.Hour, func(zapcore.Entry) { seen++ })
+	logger := zap.New(summary)
+
+	for i := 0; i < 5; i++ {
+		logger.Error("publish failed")
+	}
+	logger.Error("other failure")
+	logger.Info("not summarized")
+	logger.Info("not summarized")
+
+	assert.Equal(t, 6, seen)
+	assert.Equal(t, 1, logs.FilterMessage("publish failed").Len())
+	assert.Equal(t, 1, logs.FilterMessage("other failure").Len())
+	assert.Equal(t, 2, logs.FilterMessage("not summarized").Len())
+
+	summary.flush()
+
+	entries := logs.FilterMessage("publish failed").All()
+	require.Len(t, entries, 2)
+	assert.Equal(t, int64(4), entries[1].ContextMap()["suppressed"])
+	assert.Equal(t, 1, logs.FilterMessage("other failure").Len())
+}
@@ -3,26 +3,36 @@ package main
 import (
 	"context"
 	crand "crypto/rand"
+	"database/sql"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	// nolint:staticcheck
 	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
 	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
 	libp2p "github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/config"
 	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
 	libp2p_host "github.com/libp2p/go-libp2p/core/host"
-	metrics "github.com/libp2p/go-libp2p/core/metrics"
 	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	libp2p_relayproto "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
 	libp2p_relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	qrterminal "github.com/mdp/qrterminal/v3"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/oklog/run"
 	ff "github.com/peterbourgon/ff/v3"
@@ -35,7 +45,6 @@ import (
 
 	"berty.tech/berty/v2/go/pkg/errcode"
 	"berty.tech/weshnet/pkg/ipfsutil"
-	"berty.tech/weshnet/pkg/logutil"
 	"berty.tech/weshnet/pkg/rendezvous"
 )
 
@@ -44,20 +53,136 @@ func main() {
 
 	// opts
 	var (
-		logFormat             = "color"   // json, console, color, light-console, light-color
+		logFormat             = "color"   // json, console, color, light-console, light-color, logfmt
 		logToFile             = "stderr"  // can be stdout, stderr or a file path
 		logFilters            = "info+:*" // info and more for everything
+		logSampling           = false
+		logSamplingInitial    = 100
+		logSamplingThereafter = 100
 		serveURN              = ":memory:"
-		serveListeners        = "/ip4/0.0.0.0/tcp/4040,/ip4/0.0.0.0/udp/4141/quic"
+		serveListeners        = "/ip4/0.0.0.0/tcp/4040,/ip4/0.0.0.0/udp/4141/quic,/ip4/0.0.0.0/udp/4141/quic-v1"
 		servePK               = ""
+		servePKFile           = ""
+		servePKPhraseFile     = ""
+		servePKAutosave       = false
 		sharekeyPK            = ""
 		serveAnnounce         = ""
 		serveMetricsListeners = ""
+		serveMetricsTLSCert   = ""
+		serveMetricsTLSKey    = ""
+		serveMetricsTLSCA     = ""
+		serveMetricsAuthUser  = ""
+		serveMetricsAuthPass  = ""
+		serveAdminListener    = ""
+		serveHealthListener   = ""
+		serveBestEffortListen = false
 		genkeyType            = "Ed25519"
 		genkeyLength          = 2048
-		emitterServer         = ""
+		genkeyMnemonic        = false
+		genkeyPhraseFile      = ""
+		genkeyOutput          = ""
+		genkeyForce           = false
+		genkeyShowID          = false
+		emitterServer         stringList
 		emitterPublicAddr     = ""
-		emitterAdminKey       = ""
+		emitterAdminKey       stringList
+		serveDeploymentID     = ""
+		serveNSAllowlist      = ""
+		serveAugmentAddr      = false
+		serveDBJournalMode    = ""
+		serveDBSynchronous    = ""
+		serveDBCheckIntegrity = false
+		serveDBCheckTimeout   = time.Minute
+		servePeerIdleTimeout  = time.Duration(0)
+		serveContactInfo      = ""
+		serveDeepHealthCheck  = false
+		serveConfigFiles      pathList
+		serveLogProvenance    = false
+		emitterErrorInterval  = 10 * time.Second
+		serveMaxHandshakes    = 0
+		serveMaxConns         = 0
+		serveConnLow          = connMgrLow
+		serveConnHigh         = connMgrHigh
+		serveConnGrace        = connMgrGrace
+		serveEmptyAddrPolicy  = string(emptyAddrReject)
+		serveAccessLog        = false
+		serveStartupJitter    = time.Duration(0)
+		serveParentRDVP       = ""
+		serveParentNS         = parentNamespace
+		serveTopNSInterval    = time.Duration(0)
+		serveTopNSCount       = 10
+		serveIdentifyTimeout  = time.Duration(0)
+		serveMetricsNoGzip    = false
+		serveMetricsPath      = "/metrics"
+		serveAnnounceDNS      = ""
+		serveMaxResponseBytes = 0
+		serveMinimalGoMetrics = false
+		serveProtectedPeers   = ""
+		serveShadowDB         = ""
+		serveShadowDBSample   = 0.1
+		serveMaxNSLength      = libp2p_rp.MaxNamespaceLength
+		serveNSPattern        = ""
+		servePreferTransport  = ""
+		serveMaintenanceMsg   = ""
+		serveExpireBatchSize  = 0
+		serveGCInterval       = 5 * time.Minute
+		serveDiscCacheSize    = 0
+		serveDiscCacheTTL     = 10 * time.Second
+		serveRelayLimitDur    = libp2p_relayv2.DefaultLimit().Duration
+		serveRelayLimitData   = libp2p_relayv2.DefaultLimit().Data
+		serveRelayInfinite    = false
+		serveRelayMaxResv     = libp2p_relayv2.DefaultResources().MaxReservations
+		serveRelayMaxCircuits = libp2p_relayv2.DefaultResources().MaxCircuits
+		serveRelayBufferSize  = libp2p_relayv2.DefaultResources().BufferSize
+		serveRelayTopPeers    = 0
+		serveDisableRelay     = false
+		serveLogFiltersFile   = ""
+		serveProfilingURL     = ""
+		serveProfilingInt     = time.Minute
+		serveRelayTopInterval = 5 * time.Minute
+		serveInventoryFile    = ""
+		serveInventoryURL     = ""
+		serveMetricsWarmup    = time.Duration(0)
+		serveShardMap         = ""
+		serveInfoFile         = ""
+		serveDryRun           = false
+		serveNSAllowRefresh   = 5 * time.Minute
+		serveProtocolID       = string(libp2p_rp.RendezvousProto)
+		serveLegacyProtoID    = ""
+		serveNewNSRate        = 0.0
+		serveDiscoverRate     = 0.0
+		serveMetricsTextfile  = ""
+		serveMetricsTextInt   = 15 * time.Second
+		serveStreamReadDL     = time.Duration(0)
+		serveStreamWriteDL    = time.Duration(0)
+		serveAnnounceCheck    = false
+		serveMaxWrites        = 0
+		diffA                 = ""
+		diffB                 = ""
+		diffJSON              = false
+		dbBenchURN            = ""
+		dbBenchRegistrations  = 1000
+		dbBenchQueries        = 1000
+		dbBenchChurn          = 100
+		dbBenchNamespaces     = 10
+		dbBenchCleanup        = false
+		listURN               = ""
+		listNamespace         = ""
+		listJSON              = false
+		cardPK                = ""
+		cardPKFile            = ""
+		cardAnnounce          = ""
+		cardContact           = ""
+		cardProtocolID        = string(libp2p_rp.RendezvousProto)
+		cardQR                = false
+		benchTarget           = ""
+		benchConcurrency      = 10
+		benchNamespace        = "rdvp-bench"
+		benchDuration         = 10 * time.Second
+		monitorTarget         = ""
+		monitorInterval       = 10 * time.Second
+		monitorTimeout        = deepHealthCheckTimeout
+		monitorListener       = ""
 	)
 
 	// parse opts
@@ -65,187 +190,1201 @@ func main() {
 		serveFlags    = flag.NewFlagSet("serve", flag.ExitOnError)
 		sharekeyFlags = flag.NewFlagSet("sharekey", flag.ExitOnError)
 		genkeyFlags   = flag.NewFlagSet("genkey", flag.ExitOnError)
+		monitorFlags  = flag.NewFlagSet("monitor", flag.ExitOnError)
+		diffFlags     = flag.NewFlagSet("diff", flag.ExitOnError)
+		dbBenchFlags  = flag.NewFlagSet("db-bench", flag.ExitOnError)
+		listFlags     = flag.NewFlagSet("list", flag.ExitOnError)
+		cardFlags     = flag.NewFlagSet("contactcard", flag.ExitOnError)
+		benchFlags    = flag.NewFlagSet("bench", flag.ExitOnError)
 	)
 	setupGlobalFlags := func(fs *flag.FlagSet) {
 		fs.StringVar(&logFilters, "log.filters", logFilters, "logged namespaces")
 		fs.StringVar(&logFormat, "log.format", logFormat, "if specified, will override default log format")
 		fs.StringVar(&logToFile, "log.file", logToFile, "if specified, will log everything in JSON into a file and nothing on stderr")
+		fs.BoolVar(&logSampling, "log.sampling", logSampling, "sample the repeated log lines: each second, log the first -log.sampling.initial entries with the same level and message, then one every -log.sampling.thereafter")
+		fs.IntVar(&logSamplingInitial, "log.sampling.initial", logSamplingInitial, "entries with the same level and message logged each second before sampling, with -log.sampling")
+		fs.IntVar(&logSamplingThereafter, "log.sampling.thereafter", logSamplingThereafter, "once sampling, log one entry every this many, with -log.sampling")
 	}
 	setupGlobalFlags(serveFlags)
 	setupGlobalFlags(sharekeyFlags)
 	setupGlobalFlags(genkeyFlags)
+	setupGlobalFlags(monitorFlags)
+	setupGlobalFlags(diffFlags)
+	setupGlobalFlags(cardFlags)
+	setupGlobalFlags(dbBenchFlags)
+	setupGlobalFlags(listFlags)
 	genkeyFlags.IntVar(&genkeyLength, "length", genkeyLength, "The length (in bits) of the key generated.")
 	genkeyFlags.StringVar(&genkeyType, "type", genkeyType, "Type of the private key generated, one of : Ed25519, ECDSA, Secp256k1, RSA")
-	serveFlags.String("config", "", "config file (optional)")
+	genkeyFlags.BoolVar(&genkeyMnemonic, "mnemonic", genkeyMnemonic, "generate an Ed25519 key from a new 24 words BIP39 recovery phrase, printed on stderr")
+	genkeyFlags.StringVar(&genkeyPhraseFile, "mnemonic-file", genkeyPhraseFile, "restore the Ed25519 key from the BIP39 recovery phrase stored in this file")
+	genkeyFlags.StringVar(&genkeyOutput, "output", genkeyOutput, "if set, write the key to this file (mode 0600) instead of stdout")
+	genkeyFlags.BoolVar(&genkeyForce, "force", genkeyForce, "overwrite the -output file if it exists")
+	genkeyFlags.BoolVar(&genkeyShowID, "show-id", genkeyShowID, "also print the peer ID of the key, on stderr")
+	serveFlags.BoolVar(&serveLogProvenance, "log-config-provenance", serveLogProvenance, "at startup, log the resolved value of each flag and its source (default, config-file, env or cli), secrets are redacted")
+	serveFlags.Var(&serveConfigFiles, "config", "config file (optional), can be repeated, later files override earlier ones, JSON (.json), YAML (.yaml, .yml) or one flag value per line")
 	serveFlags.StringVar(&serveAnnounce, "announce", serveAnnounce, "addrs that will be announce by this server")
-	serveFlags.StringVar(&serveListeners, "l", serveListeners, "lists of listeners of (m)addrs separate by a comma")
+	serveFlags.StringVar(&serveAnnounceDNS, "announce-dns", serveAnnounceDNS, "if set, announce this DNS name (as /dns4 or /dns6) instead of the IP of the announced addrs")
+	serveFlags.BoolVar(&serveAnnounceCheck, "announce-check", serveAnnounceCheck, "at startup, dial each announced addr from a temporary host and warn about the ones that are not dialable ("+announceCheckTimeout.String()+" timeout), in the background")
+	serveFlags.StringVar(&servePreferTransport, "prefer-transport", servePreferTransport, "if set, announce the addrs of this transport (ie. quic, tcp) first, so clients dial it first")
+	serveFlags.StringVar(&serveListeners, "l", serveListeners, "lists of listeners of (m)addrs separate by a comma, a legacy quic listener also listens on quic-v1, webtransport listeners must use quic-v1 (ie. /ip4/0.0.0.0/udp/4141/quic-v1/webtransport)")
 	serveFlags.StringVar(&serveMetricsListeners, "metrics", serveMetricsListeners, "metrics listener, if empty will disable metrics")
+	serveFlags.StringVar(&serveMetricsTLSCert, "metrics-tls-cert", serveMetricsTLSCert, "PEM certificate of the metrics listener, serves it over https (requires -metrics-tls-key)")
+	serveFlags.StringVar(&serveMetricsTLSKey, "metrics-tls-key", serveMetricsTLSKey, "PEM private key of -metrics-tls-cert")
+	serveFlags.StringVar(&serveMetricsAuthUser, "metrics-auth-user", serveMetricsAuthUser, "if set, user required (HTTP basic auth) on the -metrics-path and /config endpoints (requires -metrics-auth-pass)")
+	serveFlags.StringVar(&serveMetricsAuthPass, "metrics-auth-pass", serveMetricsAuthPass, "password of -metrics-auth-user")
+	serveFlags.StringVar(&serveMetricsTLSCA, "metrics-tls-client-ca", serveMetricsTLSCA, "if set, PEM CA bundle the metrics scrapers certificates must be signed by (mutual TLS)")
+	serveFlags.StringVar(&serveMetricsTextfile, "metrics-textfile", serveMetricsTextfile, "if set, periodically write the metrics to this file in the Prometheus text format (ie. for the node_exporter textfile collector), with or without -metrics")
+	serveFlags.DurationVar(&serveMetricsTextInt, "metrics-textfile-interval", serveMetricsTextInt, "interval between two writes of the -metrics-textfile")
+	serveFlags.BoolVar(&serveMinimalGoMetrics, "minimal-go-metrics", serveMinimalGoMetrics, "only export rdvp_heap_inuse_bytes and rdvp_goroutines instead of the full Go runtime metrics")
+	serveFlags.StringVar(&serveMetricsPath, "metrics-path", serveMetricsPath, "path of the prometheus handler on the metrics listener (ie. /v1/metrics)")
+	serveFlags.BoolVar(&serveMetricsNoGzip, "metrics-disable-compression", serveMetricsNoGzip, "don't gzip the metrics response, even if the scraper accepts it")
+	serveFlags.BoolVar(&serveBestEffortListen, "best-effort-listeners", serveBestEffortListen, "start as long as one listener is up, instead of failing if any listener cannot be bound")
+	serveFlags.StringVar(&serveHealthListener, "health-listener", serveHealthListener, "health HTTP listener serving /healthz (liveness) and /readyz (readiness) probes, independent of -metrics, if empty will disable it")
+	serveFlags.StringVar(&serveAdminListener, "admin-listener", serveAdminListener, "admin HTTP listener (ie. 127.0.0.1:8889), unauthenticated: bind it to a trusted interface only, if empty will disable admin commands")
 	serveFlags.StringVar(&servePK, "pk", servePK, "private key (generated by `rdvp genkey`)")
+	serveFlags.StringVar(&servePKFile, "pk-file", servePKFile, "file containing the private key (see `rdvp genkey -output`), keeps the key out of the process arguments, exclusive with -pk")
+	serveFlags.StringVar(&servePKPhraseFile, "pk-mnemonic-file", servePKPhraseFile, "file containing the BIP39 recovery phrase to derive the private key from (see rdvp genkey -mnemonic), exclusive with -pk")
+	serveFlags.BoolVar(&servePKAutosave, "pk-autosave", servePKAutosave, "if no key is given, load the key saved next to the -db file ("+autosaveKeyFile+"), or generate and save it on the first run, keeps the peer ID stable across restarts (for development)")
 	serveFlags.StringVar(&serveURN, "db", serveURN, "rdvp sqlite URN")
-	serveFlags.StringVar(&emitterAdminKey, "emitter-admin-key", emitterAdminKey, "admin key of the emitter-io server")
-	serveFlags.StringVar(&emitterServer, "emitter-server", emitterServer, "address of the emitter-io server, ie. tcp://127.0.0.1:8080")
+	serveFlags.DurationVar(&serveGCInterval, "gc-interval", serveGCInterval, "interval of the deletion of the expired registrations, 0 to leave it to the db (every 15m), not supported with an in-memory db")
+	serveFlags.IntVar(&serveExpireBatchSize, "expire-batch-size", serveExpireBatchSize, "if set, the -gc-interval sweeps delete the expired registrations in batches of this size, instead of a single delete")
+	serveFlags.IntVar(&serveMaxWrites, "max-concurrent-writes", serveMaxWrites, "if set, maximum of registrations and unregistrations written to the db concurrently, the excess ones wait up to "+writeQueueTimeout.String()+", at most "+strconv.Itoa(writeQueuePerSlot)+" per slot, then are rejected")
+	serveFlags.IntVar(&serveDiscCacheSize, "discovery-cache-size", serveDiscCacheSize, "if set, cache the discovery results of this many namespaces in memory")
+	serveFlags.DurationVar(&serveDiscCacheTTL, "discovery-cache-ttl", serveDiscCacheTTL, "maximum age of the cached discovery results")
+	serveFlags.DurationVar(&serveRelayLimitDur, "relay-limit-duration", serveRelayLimitDur, "maximum duration of a relayed connection, 0 for no limit")
+	serveFlags.Int64Var(&serveRelayLimitData, "relay-limit-data", serveRelayLimitData, "maximum bytes relayed in each direction of a relayed connection, 0 for no limit")
+	serveFlags.BoolVar(&serveRelayInfinite, "relay-infinite-limits", serveRelayInfinite, "don't limit the duration and data of the relayed connections, overrides -relay-limit-duration and -relay-limit-data (not recommended, a single client can hold the relay resources)")
+	serveFlags.StringVar(&serveLogFiltersFile, "log.filters-file", serveLogFiltersFile, "if set, read the logged namespaces from this file instead of -log.filters, and read it again on SIGHUP")
+	serveFlags.StringVar(&serveProfilingURL, "profiling-endpoint", serveProfilingURL, "if set, continuously profile the process and push the CPU and heap profiles (pprof) to this Pyroscope compatible ingest URL (ie. http://pyroscope:4040/ingest)")
+	serveFlags.DurationVar(&serveProfilingInt, "profiling-interval", serveProfilingInt, "interval between two -profiling-endpoint pushes, the CPU is profiled for up to "+profilingCPUDuration.String()+" of each")
+	serveFlags.BoolVar(&serveDisableRelay, "disable-relay", serveDisableRelay, "don't act as a circuit relay (v2), only serve the rendezvous service, the -relay-* flags are ignored")
+	serveFlags.IntVar(&serveRelayMaxResv, "relay-max-reservations", serveRelayMaxResv, "maximum number of active relay reservations")
+	serveFlags.IntVar(&serveRelayMaxCircuits, "relay-max-circuits", serveRelayMaxCircuits, "maximum number of open relayed connections per peer")
+	serveFlags.IntVar(&serveRelayBufferSize, "relay-buffer-size", serveRelayBufferSize, "size (in bytes) of the buffers of each relayed connection")
+	serveFlags.IntVar(&serveRelayTopPeers, "relay-top-peers", serveRelayTopPeers, "if set, export the relayed bytes of this many reserving peers consuming the most as rdvp_relay_bytes")
+	serveFlags.DurationVar(&serveRelayTopInterval, "relay-top-peers-interval", serveRelayTopInterval, "window over which -relay-top-peers accounts the relayed bytes")
+	serveFlags.StringVar(&serveInventoryFile, "inventory-file", serveInventoryFile, "if set, write a JSON inventory of the node (peer ID, version, addrs, services, redacted config) to this file at startup")
+	serveFlags.StringVar(&serveInventoryURL, "inventory-url", serveInventoryURL, "if set, POST the JSON inventory of the node to this URL at startup")
+	serveFlags.DurationVar(&serveMetricsWarmup, "metrics-warmup", serveMetricsWarmup, "if set, the metrics endpoint answers 503 during this period after the startup, while the metrics are not meaningful yet")
+	serveFlags.StringVar(&serveShardMap, "shard-map", serveShardMap, "if set, file assigning the namespaces to the nodes of a sharded deployment, the clients are referred to the owner of the namespaces this node doesn't own")
+	serveFlags.StringVar(&serveInfoFile, "info-file", serveInfoFile, "if set, write a JSON summary of the running server (peer ID, listen and announced addrs, enabled drivers) to this file once started, it is removed on shutdown")
+	serveFlags.BoolVar(&serveDryRun, "dry-run", serveDryRun, "validate the flags, config files and key, print a summary and exit, without binding the listeners, opening the db or contacting the emitter server")
+	serveFlags.StringVar(&serveShadowDB, "shadow-db", serveShadowDB, "if set, replay the db operations on this second db and compare the results, to validate a new backend")
+	serveFlags.Float64Var(&serveShadowDBSample, "shadow-db-read-sample", serveShadowDBSample, "fraction (0.0-1.0) of the reads compared with the -shadow-db")
+	serveFlags.BoolVar(&serveDBCheckIntegrity, "db-check-integrity", serveDBCheckIntegrity, "run the sqlite integrity check on the db at startup, and refuse to start if it is corrupted")
+	serveFlags.DurationVar(&serveDBCheckTimeout, "db-check-integrity-timeout", serveDBCheckTimeout, "maximum duration of -db-check-integrity, on large dbs the check is given up (with a warning) after it")
+	serveFlags.StringVar(&serveDBSynchronous, "db-synchronous", serveDBSynchronous, "if set, the sqlite synchronous mode: off (no fsync, the db can be corrupted on power failure), normal (default, fewer fsync, with -db-journal-mode wal the last commits may be lost on power failure) or full (every commit is durable)")
+	serveFlags.StringVar(&serveDBJournalMode, "db-journal-mode", serveDBJournalMode, "if set, switch the sqlite journal mode: `wal` (faster writes, last commits may be lost on power failure) or `delete` (default, durable)")
+	serveFlags.StringVar(&serveProtocolID, "protocol-id", serveProtocolID, "protocol ID of the rendezvous service, the -deep-health-check self-test speaks "+string(libp2p_rp.RendezvousProto)+" so it must be one of the served IDs")
+	serveFlags.StringVar(&serveLegacyProtoID, "legacy-protocol-id", serveLegacyProtoID, "if set, also serve the rendezvous service under this protocol ID, for the clients not upgraded yet (see rdvp_protocol_requests_total)")
+	serveFlags.Var(&emitterAdminKey, "emitter-admin-key", "admin key of the emitter-io server, can be repeated or comma separated, in the order of the -emitter-server")
+	serveFlags.Var(&emitterServer, "emitter-server", "address of the emitter-io server, ie. tcp://127.0.0.1:8080, can be repeated or comma separated to notify several servers")
 	serveFlags.StringVar(&emitterPublicAddr, "emitter-public-addr", emitterPublicAddr, "if set, will be used to tell the client where to find emitter server")
+	serveFlags.DurationVar(&emitterErrorInterval, "emitter-error-log-interval", emitterErrorInterval, "log identical emitter errors at most once per interval with a count of the suppressed ones, 0 to log every error")
+	serveFlags.IntVar(&serveMaxResponseBytes, "max-response-bytes", serveMaxResponseBytes, "if set, cap the size of the discovery responses, the registrations that don't fit are left for the next page (cookie)")
+	serveFlags.IntVar(&serveMaxNSLength, "max-namespace-length", serveMaxNSLength, "maximum length of the registered and discovered namespaces, from 1 to "+strconv.Itoa(libp2p_rp.MaxNamespaceLength)+" (the protocol limit)")
+	serveFlags.StringVar(&serveNSPattern, "namespace-pattern", serveNSPattern, "if set, registered and discovered namespaces must match this regular expression (ie. ^[a-zA-Z0-9/._-]+$)")
+	serveFlags.StringVar(&serveNSAllowlist, "namespace-allowlist", serveNSAllowlist, "comma separated glob patterns of the namespaces served by this node, or the http(s) URL of a document listing them, if empty every namespace is allowed")
+	serveFlags.DurationVar(&serveNSAllowRefresh, "namespace-allowlist-refresh", serveNSAllowRefresh, "refresh interval of a remote -namespace-allowlist, 0 to fetch it only at startup")
+	serveFlags.Float64Var(&serveNewNSRate, "new-namespace-rate", serveNewNSRate, "if set, maximum of namespaces without active registration a peer can register in per minute (burst of the same size), registrations in existing namespaces are not limited")
+	serveFlags.Float64Var(&serveDiscoverRate, "discover-rate", serveDiscoverRate, "if set, maximum of discover queries and subscriptions per minute of a peer (burst of the same size), the excess ones are rejected with E_UNAVAILABLE")
+	serveFlags.DurationVar(&serveStreamReadDL, "stream-read-deadline", serveStreamReadDL, "if set, reset the rendezvous streams on which reading a request takes longer, including the wait for the request")
+	serveFlags.DurationVar(&serveStreamWriteDL, "stream-write-deadline", serveStreamWriteDL, "if set, reset the rendezvous streams on which writing a response takes longer")
+	serveFlags.BoolVar(&serveAccessLog, "access-log", serveAccessLog, "log an entry (peer, namespace, ttl, outcome) for each rendezvous request, in the access logger")
+	serveFlags.StringVar(&serveEmptyAddrPolicy, "empty-addr-policy", serveEmptyAddrPolicy, "handling of the registrations without usable address (empty or unspecified IP): reject, augment (with the observed address of the registrant, if public) or accept")
+	serveFlags.BoolVar(&serveAugmentAddr, "augment-observed-addr", serveAugmentAddr, "add the observed public address of the registrant to its registration (ignored for relayed and private connections)")
+	serveFlags.DurationVar(&servePeerIdleTimeout, "peer-idle-timeout", servePeerIdleTimeout, "close connections without rendezvous activity for this duration, at least "+minPeerIdleTimeout.String()+", 0 to disable")
+	serveFlags.DurationVar(&serveIdentifyTimeout, "identify-timeout", serveIdentifyTimeout, "if set, close the connections that did not complete the identify exchange within this delay")
+	serveFlags.StringVar(&serveProtectedPeers, "protected-peers", serveProtectedPeers, "comma separated peer IDs never trimmed by the connection manager, unprotected connections are evicted to make room for them")
+	serveFlags.StringVar(&serveMaintenanceMsg, "maintenance-message", serveMaintenanceMsg, "if set, start in maintenance: the message is sent in the status text of the register and discover responses (also settable on the admin /maintenance endpoint)")
+	serveFlags.StringVar(&serveContactInfo, "contact-info", serveContactInfo, "operator contact (ie. abuse email), served to peers on the "+string(contactProtocolID)+" protocol")
+	serveFlags.BoolVar(&serveDeepHealthCheck, "deep-health-check", serveDeepHealthCheck, "make /healthz run a register+discover self-test (at most every "+deepHealthCheckInterval.String()+") on the "+healthCheckNamespace+" namespace, the self-test of the node is exempted from the namespace policies (allowlist, pattern, shard map) and not forwarded to the sync drivers")
+	serveFlags.IntVar(&serveMaxConns, "max-connections", serveMaxConns, "hard limit of open connections, the inbound connections above it are rejected (the connection manager only trims them above -conn-high), 0 for no limit")
+	serveFlags.IntVar(&serveConnLow, "conn-low", serveConnLow, "connection manager low watermark, the connections are trimmed down to it")
+	serveFlags.IntVar(&serveConnHigh, "conn-high", serveConnHigh, "connection manager high watermark, the connections are trimmed above it")
+	serveFlags.DurationVar(&serveConnGrace, "conn-grace", serveConnGrace, "connection manager grace period, the new connections are not trimmed during it")
+	serveFlags.StringVar(&serveParentRDVP, "parent-rdvp", serveParentRDVP, "if set, multiaddr (including its /p2p/ peer ID) of a parent rdvp this node keeps registered at, with its announced addrs, to build a tree of nodes")
+	serveFlags.StringVar(&serveParentNS, "parent-namespace", serveParentNS, "namespace of the registration at the -parent-rdvp")
+	serveFlags.DurationVar(&serveStartupJitter, "startup-jitter", serveStartupJitter, "if set, sleep a random duration up to this one after binding the listeners, before connecting to the emitter brokers, to spread the load of a fleet restart")
+	serveFlags.IntVar(&serveMaxHandshakes, "max-concurrent-handshakes", serveMaxHandshakes, "maximum of concurrent inbound security handshakes, excess connections are rejected, a failed handshake holds its slot for "+handshakeTimeout.String()+", 0 for no limit")
+	serveFlags.DurationVar(&serveTopNSInterval, "top-namespaces-interval", serveTopNSInterval, "if set, periodically log the namespaces with the most active registrations")
+	serveFlags.IntVar(&serveTopNSCount, "top-namespaces", serveTopNSCount, "number of namespaces logged by -top-namespaces-interval and exported by rdvp_active_registrations_by_namespace")
+	serveFlags.StringVar(&serveDeploymentID, "deployment-id", serveDeploymentID, "deployment generation tag, reported in logs, metrics and on the /config endpoint")
 	sharekeyFlags.StringVar(&sharekeyPK, "pk", sharekeyPK, "private key (generated by `rdvp genkey`)")
+	diffFlags.StringVar(&diffA, "a", diffA, "first registrations snapshot (JSON)")
+	diffFlags.StringVar(&diffB, "b", diffB, "second registrations snapshot (JSON)")
+	diffFlags.BoolVar(&diffJSON, "json", diffJSON, "output the differences as JSON")
+	dbBenchFlags.StringVar(&dbBenchURN, "db", dbBenchURN, "rdvp sqlite URN of the benchmarked db")
+	dbBenchFlags.IntVar(&dbBenchRegistrations, "registrations", dbBenchRegistrations, "number of registrations, one per peer")
+	dbBenchFlags.IntVar(&dbBenchQueries, "queries", dbBenchQueries, "number of discoveries")
+	dbBenchFlags.IntVar(&dbBenchChurn, "churn", dbBenchChurn, "number of unregistration and registration of a random peer")
+	dbBenchFlags.IntVar(&dbBenchNamespaces, "namespaces", dbBenchNamespaces, "number of namespaces the registrations are spread over")
+	dbBenchFlags.BoolVar(&dbBenchCleanup, "cleanup", dbBenchCleanup, "remove the benchmark registrations afterward")
+	listFlags.StringVar(&listURN, "db", listURN, "rdvp sqlite URN of the inspected db, opened read-only")
+	listFlags.StringVar(&listNamespace, "namespace", listNamespace, "if set, only list the registrations of this namespace")
+	listFlags.BoolVar(&listJSON, "json", listJSON, "output the registrations as a JSON snapshot (see diff)")
+	cardFlags.StringVar(&cardPK, "pk", cardPK, "private key of the node (generated by `rdvp genkey`)")
+	cardFlags.StringVar(&cardPKFile, "pk-file", cardPKFile, "file containing the private key of the node, exclusive with -pk")
+	cardFlags.StringVar(&cardAnnounce, "announce", cardAnnounce, "addrs announced by the node (the serve -announce value)")
+	cardFlags.StringVar(&cardContact, "contact-info", cardContact, "operator contact (the serve -contact-info value)")
+	cardFlags.StringVar(&cardProtocolID, "protocol-id", cardProtocolID, "protocol ID of the rendezvous service (the serve -protocol-id value)")
+	cardFlags.BoolVar(&cardQR, "qr", cardQR, "also print the card as a QR code, on stderr")
+	benchFlags.StringVar(&benchTarget, "target", benchTarget, "multiaddrs (comma separated) of the benchmarked rdvp, including its /p2p/ peer ID")
+	benchFlags.IntVar(&benchConcurrency, "concurrency", benchConcurrency, "number of clients, each with its own host and identity")
+	benchFlags.StringVar(&benchNamespace, "namespace", benchNamespace, "namespace the clients register on and discover")
+	benchFlags.DurationVar(&benchDuration, "duration", benchDuration, "duration of the benchmark")
+	monitorFlags.StringVar(&monitorTarget, "target", monitorTarget, "multiaddr of the monitored rdvp, including its /p2p/ peer ID")
+	monitorFlags.DurationVar(&monitorInterval, "interval", monitorInterval, "interval between two self-tests")
+	monitorFlags.DurationVar(&monitorTimeout, "timeout", monitorTimeout, "timeout of a self-test")
+	monitorFlags.StringVar(&monitorListener, "metrics", monitorListener, "metrics listener, if empty will disable metrics")
 
 	serve := &ffcli.Command{
 		Name:       "serve",
 		ShortUsage: "rdvp [global flags] serve [flags]",
-		LongHelp:   "EXAMPLE\n  rdvp genkey > rdvp.key\n  rdvp serve -pk `cat rdvp.key` -db ./rdvp-store",
-		FlagSet:    serveFlags,
-		Options: []ff.Option{
-			ff.WithEnvVarPrefix("RDVP"),
-			ff.WithConfigFileFlag("config"),
-			ff.WithConfigFileParser(ff.PlainParser),
+		LongHelp: "EXAMPLE\n  rdvp genkey -output rdvp.key\n  rdvp serve -pk-file rdvp.key -db ./rdvp-store\n\n" +
+			"CONFIG\n  flags are resolved in this order, the last one wins:\n" +
+			"  defaults < -config files (in order) < RDVP_* env vars < command line flags\n" +
+			"  the env var of a flag is its upper-cased name prefixed with RDVP_, with - replaced by _\n" +
+			"  (ie. RDVP_DB for -db). Only serve and monitor read the env vars, the other\n" +
+			"  subcommands only take command line flags.",
+		FlagSet: serveFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 {
+				return flag.ErrHelp
+			}
+
+			// the env vars are applied here rather than by ffcli, to tell
+			// them apart from the command line flags
+			flagSources, err := resolveFlags(serveFlags, "RDVP", &serveConfigFiles)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			if serveLogFiltersFile != "" {
+				filters, err := readLogFiltersFile(serveLogFiltersFile)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				logFilters = filters
+			}
+
+			logReloader, logger, err := newReloadableLogger(logFilters, logFormat, logToFile)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			defer logReloader.close()
+
+			if logSampling {
+				if logger, err = sampledLogger(logger, logSamplingInitial, logSamplingThereafter); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			if serveLogProvenance {
+				logFlagSources(logger.Named("config"), serveFlags, flagSources)
+			}
+
+			registrations := newRegistrationIndex()
+			rmetrics := newRdvpMetrics()
+			rmetrics.setDeploymentID(serveDeploymentID)
+			rmetrics.observeRegistrations(registrations)
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			var gServe run.Group
+			gServe.Add(func() error {
+				<-ctx.Done()
+				return ctx.Err()
+			}, func(error) {
+				cancel()
+			})
+
+			gServe.Add(func() error {
+				return registrations.run(ctx, registrationIndexPruneInterval)
+			}, func(error) {
+				cancel()
+			})
+
+			if serveProfilingURL != "" {
+				if serveProfilingInt <= 0 {
+					return errcode.TODO.Wrap(fmt.Errorf("-profiling-interval must be positive"))
+				}
+
+				profilingLogger := logger.Named("profiling")
+				gServe.Add(func() error {
+					profilingLogger.Info("continuous profiling", zap.String("endpoint", stripUserinfo(serveProfilingURL)), zap.Duration("interval", serveProfilingInt))
+					return pushProfiles(ctx, profilingLogger, serveProfilingURL, serveDeploymentID, serveProfilingInt)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			if serveLogFiltersFile != "" {
+				gServe.Add(func() error {
+					return reloadLogFiltersOnSIGHUP(ctx, logger.Named("log"), logReloader, serveLogFiltersFile)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			if len(serveContactInfo) > contactMaxLength {
+				return errcode.TODO.Wrap(fmt.Errorf("contact info too long, max %d bytes", contactMaxLength))
+			}
+
+			var nsAllowlist namespaceFilter
+			if isRemoteAllowlist(serveNSAllowlist) {
+				remote, err := newRemoteAllowlist(ctx, logger.Named("allowlist"), rmetrics, serveNSAllowlist)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				if serveNSAllowRefresh > 0 {
+					gServe.Add(func() error {
+						return remote.run(ctx, serveNSAllowRefresh)
+					}, func(error) {
+						cancel()
+					})
+				}
+				nsAllowlist = remote
+			} else {
+				if nsAllowlist, err = parseNamespaceAllowlist(serveNSAllowlist); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			var shards shardMap
+			if serveShardMap != "" {
+				if shards, err = readShardMap(serveShardMap); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			if serveMaxNSLength < 1 || serveMaxNSLength > libp2p_rp.MaxNamespaceLength {
+				return errcode.TODO.Wrap(fmt.Errorf("-max-namespace-length must be between 1 and %d", libp2p_rp.MaxNamespaceLength))
+			}
+
+			var nsPattern *regexp.Regexp
+			if serveNSPattern != "" {
+				if nsPattern, err = regexp.Compile(serveNSPattern); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			protocolIDs, err := parseProtocolIDs(serveProtocolID, serveLegacyProtoID)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			emptyAddrPolicy, err := parseEmptyAddrPolicy(serveEmptyAddrPolicy)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			var newNSLimiter *peerRateLimiter
+			switch {
+			case serveNewNSRate < 0:
+				return errcode.TODO.Wrap(fmt.Errorf("-new-namespace-rate cannot be negative"))
+			case serveNewNSRate > 0:
+				newNSLimiter = newPeerRateLimiter(serveNewNSRate, peerRateLimiterPeers)
+			}
+
+			var discoverLimiter *peerRateLimiter
+			switch {
+			case serveDiscoverRate < 0:
+				return errcode.TODO.Wrap(fmt.Errorf("-discover-rate cannot be negative"))
+			case serveDiscoverRate > 0:
+				discoverLimiter = newPeerRateLimiter(serveDiscoverRate, peerRateLimiterPeers)
+			}
+
+			if err := validatePeerIdleTimeout(servePeerIdleTimeout); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			protectedPeers, err := parseProtectedPeers(serveProtectedPeers)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			laddrs := strings.Split(serveListeners, ",")
+			listeners, err := ipfsutil.ParseAddrs(laddrs...)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			if listeners, err = normalizeListeners(listeners); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			// load existing or generate new identity
+			var priv libp2p_ci.PrivKey
+			keySources := 0
+			for _, source := range []string{servePK, servePKFile, servePKPhraseFile} {
+				if source != "" {
+					keySources++
+				}
+			}
+			if keySources > 1 {
+				return errcode.TODO.Wrap(fmt.Errorf("-pk, -pk-file and -pk-mnemonic-file are mutually exclusive"))
+			}
+
+			// the key file -pk-autosave would generate, only set in dry-run
+			var autosaveKey string
+
+			pk := servePK
+			if servePKFile != "" {
+				if pk, err = readPrivateKeyFile(servePKFile); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			if servePKPhraseFile != "" {
+				priv, err = keyFromMnemonicFile(servePKPhraseFile)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			} else if pk != "" {
+				priv, err = decodePrivateKey(pk)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			} else if servePKAutosave {
+				path, err := autosaveKeyPath(serveURN)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				switch {
+				case serveDryRun:
+					// don't write the key file in dry-run, only load it if it exists
+					priv, err = loadKey(path)
+					if errors.Is(err, fs.ErrNotExist) {
+						autosaveKey = path
+						priv, _, err = libp2p_ci.GenerateEd25519Key(crand.Reader)
+					}
+					if err != nil {
+						return errcode.TODO.Wrap(err)
+					}
+				default:
+					var created bool
+					if priv, created, err = loadOrCreateKey(path); err != nil {
+						return errcode.TODO.Wrap(err)
+					}
+					if created {
+						logger.Info("generated a new key", zap.String("path", path))
+					} else {
+						logger.Info("loaded the autosaved key", zap.String("path", path))
+					}
+				}
+			} else {
+				// Don't use key params here, this is a dev tool, a real installation should use a static key.
+				priv, _, err = libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.Ed25519, -1, crand.Reader) // nolint:staticcheck
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			var (
+				addrsFactory config.AddrsFactory = func(ms []ma.Multiaddr) []ma.Multiaddr { return ms }
+				announces    []ma.Multiaddr
+			)
+			if serveAnnounce != "" {
+				aaddrs := strings.Split(serveAnnounce, ",")
+				if announces, err = ipfsutil.ParseAddrs(aaddrs...); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				addrsFactory = func([]ma.Multiaddr) []ma.Multiaddr { return announces }
+			}
+
+			if serveAnnounceDNS != "" {
+				addrsFactory = dnsAddrsFactory(serveAnnounceDNS, addrsFactory)
+			}
+
+			if servePreferTransport != "" {
+				if addrsFactory, err = preferTransportAddrsFactory(servePreferTransport, addrsFactory); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			if err := validateEmitterOptions(emitterServer, emitterAdminKey, emitterPublicAddr); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			if serveDryRun {
+				pid, err := libp2p_peer.IDFromPrivateKey(priv)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				dryRunSummary{
+					PeerID:      pid,
+					RandomKey:   servePK == "" && servePKFile == "" && servePKPhraseFile == "" && !servePKAutosave,
+					AutosaveKey: autosaveKey,
+					Listeners:   listeners,
+					Announces:   announces,
+					Emitters:    emitterServer,
+				}.print(os.Stdout)
+				return nil
+			}
+
+			reporter := newPeriodBandwidthCounter()
+			reporter.transports = newTransportBandwidth()
+			rmetrics.observeTransportBytes(reporter.transports)
+			if serveRelayTopPeers > 0 && !serveDisableRelay {
+				if serveRelayTopInterval <= 0 {
+					return errcode.TODO.Wrap(fmt.Errorf("-relay-top-peers-interval must be positive"))
+				}
+
+				reporter.relayPeers = newRelayPeerBandwidth()
+				rmetrics.observeRelayPeerBytes(reporter.relayPeers)
+				gServe.Add(func() error {
+					return rotateRelayPeerBandwidth(ctx, reporter.relayPeers, serveRelayTopPeers, serveRelayTopInterval)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			gaterLogger := logger.Named("gater")
+			gaterSummary := newSummaryCore(gaterLogger.Core(), zapcore.WarnLevel, 10*time.Second, nil)
+			gServe.Add(func() error {
+				return gaterSummary.run(ctx)
+			}, func(error) {
+				cancel()
+			})
+			gater := newConnGater(gaterLogger.WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+				return gaterSummary
+			})), rmetrics, serveMaxHandshakes, serveMaxConns)
+
+			if err := validateConnMgrOptions(serveConnLow, serveConnHigh, serveConnGrace); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			cm, err := connmgr.NewConnManager(serveConnLow, serveConnHigh, connmgr.WithGracePeriod(serveConnGrace))
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			logger.Info("connection manager", zap.Int("low", serveConnLow), zap.Int("high", serveConnHigh), zap.Duration("grace", serveConnGrace))
+			for _, p := range protectedPeers {
+				cm.Protect(p, protectedPeerTag)
+			}
+
+			// init p2p host
+			host, err := libp2p.New(
+				// tcp, quic (draft-29 and v1), websocket and webtransport
+				libp2p.DefaultTransports,
+
+				// Nat & Relay service
+
+				// @NOTE(gfanton): init relay manually
+				libp2p.DisableRelay(),
+
+				// swarm listeners are bound once the host is up, see listen
+				libp2p.NoListenAddrs,
+
+				// identity
+				libp2p.Identity(priv),
+
+				// announce
+				libp2p.AddrsFactory(addrsFactory),
+
+				// metrics
+				libp2p.BandwidthReporter(reporter),
+
+				// connection limits
+				libp2p.ConnectionGater(gater),
+				libp2p.ConnectionManager(cm),
+			)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			defer host.Close()
+
+			host.Network().Notify(gater.notifiee())
+
+			var parent *libp2p_peer.AddrInfo
+			if serveParentRDVP != "" {
+				if parent, err = parseParent(serveParentRDVP, host.ID()); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+			// no connection before listen
+			reporter.transports.network = host.Network()
+
+			if serveIdentifyTimeout > 0 {
+				if err := watchIdentify(logger.Named("identify"), rmetrics, host, serveIdentifyTimeout); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			if err := listen(logger, host, listeners, serveBestEffortListen); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			if backlog, ok := tcpListenBacklog(); ok {
+				logger.Info("tcp listen backlog, raise net.core.somaxconn to increase it", zap.Int("backlog", backlog))
+			}
+
+			if err := startupJitter(ctx, logger, serveStartupJitter); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			if parent != nil {
+				parentLogger := logger.Named("parent")
+				gServe.Add(func() error {
+					return registerWithParent(ctx, parentLogger, host, *parent, serveParentNS, rmetrics.parentRegistered)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			if serveAnnounceDNS != "" {
+				checkAnnounceDNS(ctx, logger, host, serveAnnounceDNS)
+			}
+
+			logHostInfo(logger, host, zap.String("deployment ID", serveDeploymentID))
+
+			if serveAnnounceCheck {
+				go func() {
+					failed, err := checkAnnounceDials(ctx, logger, host, announceCheckTimeout)
+					switch {
+					case err != nil && ctx.Err() == nil:
+						logger.Warn("unable to check the announced addrs", zap.Error(err))
+					case err == nil && failed == 0:
+						logger.Info("announced addrs are dialable")
+					}
+				}()
+			}
+			rmetrics.observeStreamsPerConn(host.Network())
+			rmetrics.observeConnections(host.Network())
+			gServe.Add(func() error {
+				return logConnections(ctx, logger, host.Network(), connLogInterval)
+			}, func(error) {
+				cancel()
+			})
+
+			if serveContactInfo != "" {
+				host.SetStreamHandler(contactProtocolID, contactHandler(logger, serveContactInfo))
+			}
+
+			if serveDisableRelay {
+				logger.Info("relay service disabled")
+			} else {
+				// the limits enforced on the circuits are the ones advertised to
+				// the clients in the reservation and connect responses, both
+				// come from the relay resources
+				limit := relayLimit(serveRelayLimitDur, serveRelayLimitData)
+				if serveRelayInfinite {
+					limit = nil
+				}
+				relayResources, err := newRelayResources(serveRelayMaxResv, serveRelayMaxCircuits, serveRelayBufferSize, limit)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				relayFields := []zap.Field{
+					zap.Int("max-reservations", relayResources.MaxReservations),
+					zap.Int("max-circuits", relayResources.MaxCircuits),
+					zap.Int("buffer-size", relayResources.BufferSize),
+				}
+				if relayResources.Limit != nil {
+					relayFields = append(relayFields, zap.Duration("duration", relayResources.Limit.Duration), zap.Int64("data", relayResources.Limit.Data))
+				} else {
+					relayFields = append(relayFields, zap.String("limits", "none"))
+				}
+				logger.Info("relay service enabled", relayFields...)
+
+				_, err = libp2p_relayv2.New(host, libp2p_relayv2.WithResources(relayResources))
+				if err != nil {
+					return fmt.Errorf("unable to start relay v2; %w", err)
+				}
+			}
+
+			dsn, err := dbDSN(serveURN, serveDBSynchronous)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			db, err := openRendezvousDB(ctx, serveURN, dsn)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			if serveDBSynchronous != "" {
+				logger.Info("db synchronous mode", zap.String("mode", strings.ToLower(serveDBSynchronous)))
+			}
+
+			defer db.Close()
+
+			if serveDBCheckIntegrity {
+				if serveURN == memoryDBURN {
+					return errcode.TODO.Wrap(fmt.Errorf("-db-check-integrity is not supported with an in-memory db"))
+				}
+
+				checkCtx, checkCancel := context.WithTimeout(ctx, serveDBCheckTimeout)
+				start := time.Now()
+				problems, err := checkDBIntegrity(checkCtx, serveURN)
+				checkCancel()
+				switch {
+				case err != nil && ctx.Err() == nil && checkCtx.Err() != nil:
+					logger.Warn("db integrity check timed out, skipped", zap.Duration("timeout", serveDBCheckTimeout))
+				case err != nil:
+					return errcode.TODO.Wrap(fmt.Errorf("unable to check the db integrity: %w", err))
+				case len(problems) > 0:
+					logger.Error("db integrity check failed", zap.Strings("problems", problems))
+					return errcode.TODO.Wrap(fmt.Errorf("the db is corrupted (%d problems found)", len(problems)))
+				default:
+					logger.Info("db integrity checked", zap.Duration("duration", time.Since(start)))
+				}
+			}
+
+			if serveDBJournalMode != "" {
+				mode, err := setDBJournalMode(ctx, serveURN, serveDBJournalMode)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				if mode != strings.ToLower(serveDBJournalMode) {
+					logger.Warn("unable to change db journal mode", zap.String("wanted", serveDBJournalMode), zap.String("current", mode))
+				} else {
+					logger.Info("db journal mode", zap.String("mode", mode))
+				}
+			}
+
+			var serviceDB libp2p_rpdbi.DB = db
+			if serveShadowDB != "" {
+				shadow, err := libp2p_rpdb.OpenDB(ctx, serveShadowDB)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				defer shadow.Close()
+
+				serviceDB = newShadowDB(logger.Named("shadowdb"), rmetrics, db, shadow, serveShadowDBSample)
+			}
+
+			if serveMaxWrites > 0 {
+				serviceDB = newWriteLimitedDB(rmetrics, serviceDB, serveMaxWrites)
+			}
+
+			if serveDiscCacheSize > 0 {
+				if serveDiscCacheTTL <= 0 {
+					return errcode.TODO.Wrap(fmt.Errorf("-discovery-cache-ttl must be positive"))
+				}
+
+				serviceDB = newCachedDB(rmetrics, serviceDB, serveDiscCacheSize, serveDiscCacheTTL)
+			}
+
+			// direct access to the db file, for the queries the rendezvous
+			// db doesn't offer (not possible with an in-memory db)
+			var rawDB *sql.DB
+			if serveURN != memoryDBURN {
+				// same DSN, the sweeper writes to it
+				if rawDB, err = sql.Open("sqlite3", dsn); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				defer rawDB.Close()
+
+				loaded, err := registrations.load(ctx, rawDB, libp2p_rp.MaxTTL)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				logger.Debug("active registrations loaded in the index", zap.Int("count", loaded))
+			}
+
+			switch {
+			case serveGCInterval <= 0:
+				if serveExpireBatchSize > 0 {
+					return errcode.TODO.Wrap(fmt.Errorf("-expire-batch-size requires -gc-interval"))
+				}
+			case rawDB == nil:
+				if serveExpireBatchSize > 0 {
+					return errcode.TODO.Wrap(fmt.Errorf("-expire-batch-size is not supported with an in-memory db"))
+				}
+				logger.Debug("-gc-interval ignored with an in-memory db")
+			default:
+				sweeper := newExpireSweeper(logger.Named("sweep"), rmetrics, rawDB, serveExpireBatchSize)
+				gServe.Add(func() error {
+					return sweeper.run(ctx, serveGCInterval)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			var (
+				top      topNamespacesFunc      = registrations.topNamespaces
+				count    countRegistrationsFunc = registrations.count
+				nsExists namespaceExistsFunc    = registrations.namespaceExists
+			)
+			if rawDB != nil {
+				top = dbTopNamespaces(rawDB)
+				count = dbCountRegistrations(rawDB)
+				nsExists = dbNamespaceExists(rawDB)
+			}
+			rmetrics.observeActiveRegistrations(count, top, serveTopNSCount)
+
+			if serveTopNSInterval > 0 {
+				gServe.Add(func() error {
+					return logTopNamespaces(ctx, logger.Named("topns"), top, serveTopNSCount, serveTopNSInterval)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			var syncDrivers []libp2p_rp.RendezvousSync
+
+			for i := range emitterServer {
+				server, adminKey := emitterServer[i], emitterAdminKey[i]
+				name := emitterDriverName(i)
+
+				emitterLogger := logger.Named(name)
+				if emitterErrorInterval > 0 {
+					summary := newSummaryCore(emitterLogger.Core(), zapcore.ErrorLevel, emitterErrorInterval, func(ent zapcore.Entry) {
+						rmetrics.syncDriverErrors.WithLabelValues(name, ent.Message).Inc()
+					})
+					emitterLogger = emitterLogger.WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+						return summary
+					}))
+					gServe.Add(func() error {
+						return summary.run(ctx)
+					}, func(error) {
+						cancel()
+					})
+				}
+
+				emitter, err := rendezvous.NewEmitterServer(server, adminKey, &rendezvous.EmitterOptions{
+					Logger:           emitterLogger,
+					ServerPublicAddr: emitterPublicAddr,
+				})
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				defer emitter.Close()
+
+				logger.Info("connected to mqtt broker", zap.String("broker", server))
+				syncDrivers = append(syncDrivers, emitter)
+
+				emitterUp := rmetrics.dependencyUp.WithLabelValues(name)
+				gServe.Add(func() error {
+					return probeDependency(ctx, emitterLogger, emitterUp, server, dependencyProbeInterval)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			var idle *idleTracker
+			if servePeerIdleTimeout > 0 {
+				idle = newIdleTracker(logger.Named("idle"), rmetrics, host, servePeerIdleTimeout)
+				gServe.Add(func() error {
+					return idle.run(ctx)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			if len(protectedPeers) > 0 {
+				_ = newProtectedConnEvictor(logger.Named("evict"), rmetrics, host, idle, serveConnHigh)
+			}
+
+			var accessLogger *zap.Logger
+			if serveAccessLog {
+				accessLogger = logger.Named("access")
+			}
+
+			// start service
+			health, err := newHealthChecker(host, serveDeepHealthCheck)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			defer health.Close()
+
+			svc := newService(host, serviceDB, serviceOptions{
+				Logger:              logger.Named("rdvp"),
+				Metrics:             rmetrics,
+				Registrations:       registrations,
+				NamespaceAllowlist:  nsAllowlist,
+				AugmentObservedAddr: serveAugmentAddr,
+				IdleTracker:         idle,
+				MaxResponseBytes:    serveMaxResponseBytes,
+				MaxNamespaceLength:  serveMaxNSLength,
+				NamespacePattern:    nsPattern,
+				MaintenanceMessage:  serveMaintenanceMsg,
+				ShardMap:            shards,
+				ProtocolIDs:         protocolIDs,
+				NewNamespaceLimiter: newNSLimiter,
+				NamespaceExists:     nsExists,
+				DiscoverLimiter:     discoverLimiter,
+				StreamReadDeadline:  serveStreamReadDL,
+				StreamWriteDeadline: serveStreamWriteDL,
+				EmptyAddrPolicy:     emptyAddrPolicy,
+				AccessLogger:        accessLogger,
+				HealthCheckPeer:     health.clientID(),
+			}, syncDrivers...)
+
+			// the db is open and svc registered its handlers on the host
+			health.markReady()
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(collectors.NewBuildInfoCollector())
+			if serveMinimalGoMetrics {
+				registry.MustRegister(minimalGoCollectors()...)
+			} else {
+				registry.MustRegister(collectors.NewGoCollector())
+			}
+			registry.MustRegister(ipfsutil.NewHostCollector(host))
+			registry.MustRegister(ipfsutil.NewBandwidthCollector(reporter.BandwidthCounter))
+			registry.MustRegister(rmetrics)
+
+			if serveMetricsTextfile != "" {
+				if serveMetricsTextInt <= 0 {
+					return errcode.TODO.Wrap(fmt.Errorf("-metrics-textfile-interval must be positive"))
+				}
+
+				textfileLogger := logger.Named("metrics")
+				gServe.Add(func() error {
+					textfileLogger.Info("metrics textfile", zap.String("path", serveMetricsTextfile))
+					return writeMetricsTextfile(ctx, textfileLogger, registry, serveMetricsTextfile, serveMetricsTextInt)
+				}, func(error) {
+					cancel()
+				})
+			}
+
+			var metricsAddr net.Addr
+			if serveMetricsListeners != "" {
+				tlsConfig, err := metricsTLSConfig(serveMetricsTLSCert, serveMetricsTLSKey, serveMetricsTLSCA)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				if err := validateMetricsAuth(serveMetricsAuthUser, serveMetricsAuthPass); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				if err := validateMetricsPath(serveMetricsPath); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				ml, err := net.Listen("tcp", serveMetricsListeners)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				metricsAddr = ml.Addr()
+
+				handerfor := promhttp.HandlerFor(
+					registry,
+					promhttp.HandlerOpts{
+						Registry: registry,
+						// gzip only if the scraper sends `Accept-Encoding: gzip`
+						DisableCompression: serveMetricsNoGzip,
+					},
+				)
+
+				if serveMetricsWarmup > 0 {
+					handerfor = metricsWarmupHandler(rmetrics, serveMetricsWarmup, handerfor)
+				}
+
+				// the health probes stay unauthenticated
+				config := configHandler(serveFlags)
+				if serveMetricsAuthUser != "" {
+					handerfor = basicAuthHandler(serveMetricsAuthUser, serveMetricsAuthPass, handerfor)
+					config = basicAuthHandler(serveMetricsAuthUser, serveMetricsAuthPass, config)
+				}
+
+				mux := http.NewServeMux()
+				server := &http.Server{
+					Handler:           mux,
+					ReadHeaderTimeout: 3 * time.Second,
+					TLSConfig:         tlsConfig,
+				}
+				gServe.Add(func() error {
+					mux.Handle(serveMetricsPath, handerfor)
+					mux.Handle("/config", config)
+					mux.Handle("/healthz", health)
+					mux.Handle("/readyz", health.readyHandler())
+					logger.Info("metrics listener",
+						zap.String("handler", serveMetricsPath),
+						zap.String("listener", ml.Addr().String()),
+						zap.Bool("tls", tlsConfig != nil),
+						zap.Bool("mutual tls", serveMetricsTLSCA != ""),
+						zap.Bool("basic auth", serveMetricsAuthUser != ""))
+
+					if tlsConfig != nil {
+						// the certificate is already in tlsConfig
+						return server.ServeTLS(ml, "", "")
+					}
+					return server.Serve(ml)
+				}, func(error) {
+					shutdownHTTPServer(logger.Named("metrics"), server, metricsShutdownTimeout)
+					ml.Close()
+				})
+			}
+
+			if serveHealthListener != "" {
+				hl, err := net.Listen("tcp", serveHealthListener)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				mux := http.NewServeMux()
+				mux.Handle("/healthz", health)
+				mux.Handle("/readyz", health.readyHandler())
+				server := &http.Server{
+					Handler:           mux,
+					ReadHeaderTimeout: 3 * time.Second,
+				}
+				gServe.Add(func() error {
+					logger.Info("health listener", zap.String("listener", hl.Addr().String()))
+					return server.Serve(hl)
+				}, func(error) {
+					shutdownHTTPServer(logger.Named("health"), server, metricsShutdownTimeout)
+					hl.Close()
+				})
+			}
+
+			if serveAdminListener != "" {
+				al, err := net.Listen("tcp", serveAdminListener)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				server := &http.Server{
+					Handler:           newAdminHandler(logger.Named("admin"), host, svc, reporter),
+					ReadHeaderTimeout: 3 * time.Second,
+				}
+				gServe.Add(func() error {
+					logger.Info("admin listener", zap.String("listener", al.Addr().String()))
+					return server.Serve(al)
+				}, func(error) {
+					shutdownHTTPServer(logger.Named("admin"), server, metricsShutdownTimeout)
+					al.Close()
+				})
+			}
+
+			if serveInventoryFile != "" || serveInventoryURL != "" {
+				services := []string{"rendezvous"}
+				if !serveDisableRelay {
+					services = append(services, "relay")
+				}
+				if serveContactInfo != "" {
+					services = append(services, "contact")
+				}
+				if len(syncDrivers) > 0 {
+					services = append(services, "emitter-sync")
+				}
+				if serveMetricsListeners != "" {
+					services = append(services, "metrics")
+				}
+				if serveAdminListener != "" {
+					services = append(services, "admin")
+				}
+				if serveHealthListener != "" {
+					services = append(services, "health")
+				}
+				if parent != nil {
+					services = append(services, "parent-registration")
+				}
+
+				inv := newNodeInventory(host, serveFlags, serveDeploymentID, serveURN, services)
+				if serveInventoryFile != "" {
+					if err := inv.writeFile(serveInventoryFile); err != nil {
+						return errcode.TODO.Wrap(err)
+					}
+				}
+
+				if serveInventoryURL != "" {
+					// the inventory is informative, don't block the startup on it
+					go func() {
+						if err := inv.post(ctx, serveInventoryURL); err != nil {
+							logger.Warn("unable to post the inventory", zap.String("url", stripUserinfo(serveInventoryURL)), zap.Error(err))
+						}
+					}()
+				}
+			}
+
+			if serveInfoFile != "" {
+				var metricsListener string
+				if metricsAddr != nil {
+					metricsListener = metricsAddr.String()
+				}
+
+				if err := writeJSONFile(serveInfoFile, newServerInfo(host, len(syncDrivers) > 0, metricsListener)); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+			}
+
+			// READY=1 and watchdog heartbeats, when run under systemd
+			gServe.Add(func() error {
+				return runSystemdWatchdog(ctx, logger.Named("systemd"), health.check)
+			}, func(error) {
+				cancel()
+			})
+
+			err = gServe.Run()
+			if serveInfoFile != "" {
+				if err := os.Remove(serveInfoFile); err != nil {
+					logger.Warn("unable to remove the info file", zap.Error(err))
+				}
+			}
+			logShutdown(logger, rmetrics.uptime(), err, context.Cause(ctx))
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			return nil
 		},
+	}
+
+	sharekey := &ffcli.Command{
+		Name:       "sharekey",
+		ShortUsage: "rdvp [global flags] sharekey -pk PK",
+		FlagSet:    sharekeyFlags,
 		Exec: func(ctx context.Context, args []string) error {
 			if len(args) > 0 {
 				return flag.ErrHelp
 			}
 
-			logger, cleanup, err := logutil.NewLogger(logutil.NewStdStream(logFilters, logFormat, logToFile))
+			if sharekeyPK == "" {
+				return flag.ErrHelp
+			}
+
+			priv, err := decodePrivateKey(sharekeyPK)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
-			defer cleanup()
-
-			ctx, cancel := context.WithCancel(ctx)
-			defer cancel()
-
-			var gServe run.Group
-			gServe.Add(func() error {
-				<-ctx.Done()
-				return ctx.Err()
-			}, func(error) {
-				cancel()
-			})
 
-			laddrs := strings.Split(serveListeners, ",")
-			listeners, err := ipfsutil.ParseAddrs(laddrs...)
+			// init p2p host
+			host, err := libp2p.New(libp2p.Identity(priv))
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
+			defer host.Close()
+			fmt.Println(host.ID().String())
+			return nil
+		},
+	}
 
-			// load existing or generate new identity
-			var priv libp2p_ci.PrivKey
-			if servePK != "" {
-				kbytes, err := base64.StdEncoding.DecodeString(servePK)
-				if err != nil {
-					return errcode.TODO.Wrap(err)
-				}
-				priv, err = libp2p_ci.UnmarshalPrivateKey(kbytes)
-				if err != nil {
-					return errcode.TODO.Wrap(err)
-				}
-			} else {
-				// Don't use key params here, this is a dev tool, a real installation should use a static key.
-				priv, _, err = libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.Ed25519, -1, crand.Reader) // nolint:staticcheck
-				if err != nil {
-					return errcode.TODO.Wrap(err)
-				}
+	contactcard := &ffcli.Command{
+		Name:       "contactcard",
+		ShortUsage: "rdvp [global flags] contactcard -pk-file FILE -announce MADDRS [flags]",
+		ShortHelp:  "print the signed JSON contact card of a node",
+		LongHelp: "EXAMPLE\n  rdvp contactcard -pk-file rdvp.key -announce /ip4/1.2.3.4/tcp/4040 -contact-info abuse@example.com -qr\n\n" +
+			"the card lists the peer ID, the dialable addrs and the protocols of the node, it is\n" +
+			"signed by the node key so it can be shared through untrusted channels.",
+		FlagSet: cardFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 || cardAnnounce == "" {
+				return flag.ErrHelp
 			}
 
-			var addrsFactory config.AddrsFactory = func(ms []ma.Multiaddr) []ma.Multiaddr { return ms }
-			if serveAnnounce != "" {
-				aaddrs := strings.Split(serveAnnounce, ",")
-				announces, err := ipfsutil.ParseAddrs(aaddrs...)
-				if err != nil {
+			if cardPK != "" && cardPKFile != "" {
+				return errcode.TODO.Wrap(fmt.Errorf("-pk and -pk-file are mutually exclusive"))
+			}
+			if len(cardContact) > contactMaxLength {
+				return errcode.TODO.Wrap(fmt.Errorf("-contact-info is too long (%d bytes, max %d)", len(cardContact), contactMaxLength))
+			}
+
+			pk := cardPK
+			if cardPKFile != "" {
+				var err error
+				if pk, err = readPrivateKeyFile(cardPKFile); err != nil {
 					return errcode.TODO.Wrap(err)
 				}
-
-				addrsFactory = func([]ma.Multiaddr) []ma.Multiaddr { return announces }
+			}
+			if pk == "" {
+				return flag.ErrHelp
 			}
 
-			reporter := metrics.NewBandwidthCounter()
-
-			// init p2p host
-			host, err := libp2p.New(
-				// default tpt + quic
-				libp2p.DefaultTransports,
-
-				// Nat & Relay service
-
-				// @NOTE(gfanton): init relay manually
-				libp2p.DisableRelay(),
-
-				// swarm listeners
-				libp2p.ListenAddrs(listeners...),
-
-				// identity
-				libp2p.Identity(priv),
-
-				// announce
-				libp2p.AddrsFactory(addrsFactory),
-
-				// metrics
-				libp2p.BandwidthReporter(reporter),
-			)
+			priv, err := decodePrivateKey(pk)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
 
-			defer host.Close()
-			logHostInfo(logger, host)
-
-			_, err = libp2p_relayv2.New(host,
-				// disable limits for now to have an equivalent of a relay v1
-				libp2p_relayv2.WithInfiniteLimits(),
-				libp2p_relayv2.WithResources(libp2p_relayv2.DefaultResources()),
-			)
+			announces, err := ipfsutil.ParseAddrs(strings.Split(cardAnnounce, ",")...)
 			if err != nil {
-				return fmt.Errorf("unable to start relay v2; %w", err)
+				return errcode.TODO.Wrap(err)
 			}
 
-			db, err := libp2p_rpdb.OpenDB(ctx, serveURN)
+			protocols, err := parseProtocolIDs(cardProtocolID, "")
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
+			protocols = append(protocols, libp2p_relayproto.ProtoIDv2Hop)
+			if cardContact != "" {
+				protocols = append(protocols, contactProtocolID)
+			}
 
-			defer db.Close()
+			card, err := newContactCard(priv, announces, protocols, cardContact)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
 
-			var syncDrivers []libp2p_rp.RendezvousSync
+			out, err := json.MarshalIndent(card, "", "  ")
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			fmt.Println(string(out))
 
-			if emitterServer != "" && emitterAdminKey != "" {
-				emitter, err := rendezvous.NewEmitterServer(emitterServer, emitterAdminKey, &rendezvous.EmitterOptions{
-					Logger:           logger.Named("emitter"),
-					ServerPublicAddr: emitterPublicAddr,
-				})
+			if cardQR {
+				compact, err := json.Marshal(card)
 				if err != nil {
 					return errcode.TODO.Wrap(err)
 				}
-				defer emitter.Close()
+				qrterminal.GenerateHalfBlock(string(compact), qrterminal.L, os.Stderr)
+			}
+			return nil
+		},
+	}
 
-				logger.Info("connected to mqtt broker", zap.String("broker", emitterServer))
-				syncDrivers = append(syncDrivers, emitter)
+	monitor := &ffcli.Command{
+		Name:       "monitor",
+		ShortUsage: "rdvp [global flags] monitor -target MADDR [flags]",
+		ShortHelp:  "continuously run a register+discover self-test against a remote rdvp",
+		LongHelp: "EXAMPLE\n  rdvp monitor -target /ip4/1.2.3.4/tcp/4040/p2p/12D3KooW... -metrics :8890\n\n" +
+			"the self-test registers on the `" + healthCheckNamespace + "` namespace, it should be part of\n" +
+			"the namespace allowlist of the target if any.\n\n" +
+			"the flags can also be set with RDVP_* env vars (ie. RDVP_TARGET for -target).",
+		FlagSet: monitorFlags,
+		Options: []ff.Option{ff.WithEnvVarPrefix("RDVP")},
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 || monitorTarget == "" {
+				return flag.ErrHelp
 			}
 
-			// start service
-			_ = libp2p_rp.NewRendezvousService(host, db, syncDrivers...)
+			if err := validateMonitorOptions(monitorInterval, monitorTimeout); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
 
-			if serveMetricsListeners != "" {
-				ml, err := net.Listen("tcp", serveMetricsListeners)
+			target, err := libp2p_peer.AddrInfoFromString(monitorTarget)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			client, err := libp2p.New(
+				libp2p.DisableRelay(),
+				libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+			)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			defer client.Close()
+
+			mmetrics := newMonitorMetrics(target.ID)
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			var gMonitor run.Group
+			gMonitor.Add(func() error {
+				return runMonitor(ctx, os.Stdout, client, *target, monitorInterval, monitorTimeout, mmetrics)
+			}, func(error) {
+				cancel()
+			})
+
+			if monitorListener != "" {
+				ml, err := net.Listen("tcp", monitorListener)
 				if err != nil {
 					return errcode.TODO.Wrap(err)
 				}
 
 				registry := prometheus.NewRegistry()
-				registry.MustRegister(collectors.NewBuildInfoCollector())
-				registry.MustRegister(collectors.NewGoCollector())
-				registry.MustRegister(ipfsutil.NewHostCollector(host))
-				registry.MustRegister(ipfsutil.NewBandwidthCollector(reporter))
-				// @TODO(gfanton): add rdvp specific collector...
-
-				handerfor := promhttp.HandlerFor(
-					registry,
-					promhttp.HandlerOpts{Registry: registry},
-				)
+				registry.MustRegister(mmetrics.collectors()...)
 
 				mux := http.NewServeMux()
-				gServe.Add(func() error {
-					mux.Handle("/metrics", handerfor)
-					logger.Info("metrics listener",
-						zap.String("handler", "/metrics"),
-						zap.String("listener", ml.Addr().String()))
-
+				mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry}))
+				gMonitor.Add(func() error {
 					server := &http.Server{
 						Handler:           mux,
 						ReadHeaderTimeout: 3 * time.Second,
@@ -257,55 +1396,196 @@ func main() {
 				})
 			}
 
-			if err = gServe.Run(); err != nil {
+			return gMonitor.Run()
+		},
+	}
+
+	diff := &ffcli.Command{
+		Name:       "diff",
+		ShortUsage: "rdvp [global flags] diff -a SNAPSHOT -b SNAPSHOT [-json]",
+		ShortHelp:  "compare two registrations snapshots",
+		LongHelp: "registrations are matched by namespace and peer, the text output has one line per difference:\n" +
+			"  - ns peer          only in a\n" +
+			"  + ns peer          only in b\n" +
+			"  ~ ns peer: ...     in both, with a different expiration (b - a) and/or addresses",
+		FlagSet: diffFlags,
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) > 0 || diffA == "" || diffB == "" {
+				return flag.ErrHelp
+			}
+
+			a, err := readSnapshot(diffA)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			b, err := readSnapshot(diffB)
+			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
+
+			d := diffSnapshots(a, b)
+			if diffJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(d)
+			}
+
+			printSnapshotDiff(os.Stdout, d)
 			return nil
 		},
 	}
 
-	sharekey := &ffcli.Command{
-		Name:       "sharekey",
-		ShortUsage: "rdvp [global flags] sharekey -pk PK",
-		FlagSet:    sharekeyFlags,
+	dbBench := &ffcli.Command{
+		Name:       "db-bench",
+		ShortUsage: "rdvp [global flags] db-bench -db URN [flags]",
+		ShortHelp:  "benchmark a db backend, without the network",
+		LongHelp: "the registrations are made in namespaces prefixed with " + dbBenchNamespacePrefix + ", they are\n" +
+			"left in the db unless -cleanup is set. The operations run sequentially, the latencies\n" +
+			"and ops/s are per operation kind.",
+		FlagSet: dbBenchFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			if len(args) > 0 {
+			if len(args) > 0 || dbBenchURN == "" {
 				return flag.ErrHelp
 			}
 
-			if sharekeyPK == "" {
+			db, err := libp2p_rpdb.OpenDB(ctx, dbBenchURN)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			defer db.Close()
+
+			results, err := runDBBench(ctx, db, dbBenchOptions{
+				Registrations: dbBenchRegistrations,
+				Queries:       dbBenchQueries,
+				Churn:         dbBenchChurn,
+				Namespaces:    dbBenchNamespaces,
+				Cleanup:       dbBenchCleanup,
+			})
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			printDBBenchResults(os.Stdout, results)
+			return nil
+		},
+	}
+
+	bench := &ffcli.Command{
+		Name:       "bench",
+		ShortUsage: "rdvp [global flags] bench -target MADDR [flags]",
+		ShortHelp:  "stress-test a running rdvp with concurrent register+discover clients",
+		LongHelp: "EXAMPLE\n  rdvp bench -target /ip4/1.2.3.4/tcp/4040/p2p/12D3KooW... -concurrency 50 -duration 1m\n\n" +
+			"each client registers on -namespace then discovers it, in a loop, the namespace should\n" +
+			"be part of the namespace allowlist of the target if any. The registrations are removed\n" +
+			"at the end, the ops/s are for all the clients.",
+		FlagSet: benchFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 || benchTarget == "" {
 				return flag.ErrHelp
 			}
 
-			kbytes, err := base64.StdEncoding.DecodeString(sharekeyPK)
+			addrs, err := ipfsutil.ParseAddrs(strings.Split(benchTarget, ",")...)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
-			priv, err := libp2p_ci.UnmarshalPrivateKey(kbytes)
+			targets, err := libp2p_peer.AddrInfosFromP2pAddrs(addrs...)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
+			if len(targets) != 1 {
+				return errcode.TODO.Wrap(fmt.Errorf("-target should be the addrs of a single peer, got %d peers", len(targets)))
+			}
 
-			// init p2p host
-			host, err := libp2p.New(libp2p.Identity(priv))
+			results, err := runNetBench(ctx, targets[0], netBenchOptions{
+				Concurrency: benchConcurrency,
+				Namespace:   benchNamespace,
+				Duration:    benchDuration,
+			})
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
-			defer host.Close()
-			fmt.Println(host.ID().String())
+
+			printNetBenchResults(os.Stdout, results)
+			return nil
+		},
+	}
+
+	list := &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "rdvp [global flags] list -db URN [-namespace NS] [-json]",
+		ShortHelp:  "list the active registrations of a db",
+		LongHelp: "the db is opened read-only, it can be inspected while a server is using it.\n" +
+			"The JSON output is a registrations snapshot, it can be compared with diff.",
+		FlagSet: listFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 || listURN == "" {
+				return flag.ErrHelp
+			}
+
+			db, err := openDBReadOnly(listURN)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			defer db.Close()
+
+			regs, err := listRegistrations(ctx, db, listNamespace)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			if listJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(regs)
+			}
+
+			printRegistrations(os.Stdout, regs, time.Now())
 			return nil
 		},
 	}
 
 	genkey := &ffcli.Command{
-		Name:    "genkey",
+		Name: "genkey",
+		LongHelp: "RECOVERY PHRASE\n" +
+			"  -mnemonic generates a new 24 words BIP39 phrase, printed on stderr, and the key\n" +
+			"  derived from it. The same phrase always gives the same key and peer ID, so\n" +
+			"  -mnemonic-file and `rdvp serve -pk-mnemonic-file` can restore the node identity\n" +
+			"  from it (the words and the checksum are checked).\n" +
+			"  The phrase IS the private key: store it offline and as securely as the key itself.",
 		FlagSet: genkeyFlags,
 		Exec: func(context.Context, []string) error {
-			keyType, ok := keyNameToKeyType[strings.ToLower(genkeyType)]
-			if !ok {
-				return fmt.Errorf("unknown key type : '%s'. Only Ed25519, ECDSA, Secp256k1, RSA supported", genkeyType)
+			var (
+				priv libp2p_ci.PrivKey
+				err  error
+			)
+			switch {
+			case genkeyMnemonic && genkeyPhraseFile != "":
+				return errcode.TODO.Wrap(fmt.Errorf("-mnemonic and -mnemonic-file are mutually exclusive"))
+			case genkeyMnemonic:
+				entropy := make([]byte, mnemonicEntropyLength)
+				if _, err := crand.Read(entropy); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				var phrase string
+				if phrase, err = newMnemonic(entropy); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				priv, err = keyFromMnemonic(phrase)
+				if err == nil {
+					fmt.Fprintln(os.Stderr, "recovery phrase (store it offline, it is the private key):")
+					fmt.Fprintln(os.Stderr, phrase)
+				}
+			case genkeyPhraseFile != "":
+				priv, err = keyFromMnemonicFile(genkeyPhraseFile)
+			default:
+				keyType, ok := keyNameToKeyType[strings.ToLower(genkeyType)]
+				if !ok {
+					return fmt.Errorf("unknown key type : '%s'. Only Ed25519, ECDSA, Secp256k1, RSA supported", genkeyType)
+				}
+				priv, _, err = libp2p_ci.GenerateKeyPairWithReader(keyType, genkeyLength, crand.Reader) // nolint:staticcheck
 			}
-			priv, _, err := libp2p_ci.GenerateKeyPairWithReader(keyType, genkeyLength, crand.Reader) // nolint:staticcheck
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
@@ -315,7 +1595,34 @@ func main() {
 				return errcode.TODO.Wrap(err)
 			}
 
-			fmt.Println(base64.StdEncoding.EncodeToString(kbytes))
+			if genkeyShowID {
+				pid, err := libp2p_peer.IDFromPrivateKey(priv)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				fmt.Fprintln(os.Stderr, pid.String())
+			}
+
+			if genkeyOutput == "" {
+				fmt.Println(base64.StdEncoding.EncodeToString(kbytes))
+				return nil
+			}
+
+			if !genkeyForce {
+				if _, err := os.Stat(genkeyOutput); err == nil {
+					return errcode.TODO.Wrap(fmt.Errorf("`%s` already exists, use -force to overwrite it", genkeyOutput))
+				}
+			}
+
+			if err := os.WriteFile(genkeyOutput, []byte(base64.StdEncoding.EncodeToString(kbytes)+"\n"), 0o600); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
+			// WriteFile keeps the mode of an overwritten file
+			if err := os.Chmod(genkeyOutput, 0o600); err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+
 			return nil
 		},
 	}
@@ -323,14 +1630,14 @@ func main() {
 	root := &ffcli.Command{
 		ShortUsage:  "rdvp [global flags] <subcommand>",
 		Options:     []ff.Option{ff.WithEnvVarPrefix("RDVP")},
-		Subcommands: []*ffcli.Command{serve, genkey, sharekey},
+		Subcommands: []*ffcli.Command{serve, genkey, sharekey, contactcard, monitor, diff, dbBench, bench, list},
 		Exec: func(context.Context, []string) error {
 			return flag.ErrHelp
 		},
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
 	var process run.Group
 	// handle close signal
@@ -340,15 +1647,23 @@ func main() {
 	// add root command to process
 	process.Add(func() error {
 		return root.ParseAndRun(ctx, os.Args[1:])
-	}, func(error) {
-		cancel()
+	}, func(err error) {
+		// keep the interrupt reason, so commands can report it
+		cancel(err)
 	})
 
 	// run process
-	if err := process.Run(); err != nil && err != context.Canceled {
-		log.Println(err)
+	err := process.Run()
+	if err == nil || err == context.Canceled || errors.Is(err, flag.ErrHelp) {
 		return
 	}
+
+	log.Println(err)
+	// interrupted by a signal is a clean shutdown
+	var sigErr run.SignalError
+	if !errors.As(err, &sigErr) {
+		os.Exit(1)
+	}
 }
 
 // Names are in lower case.
@@ -361,11 +1676,35 @@ var keyNameToKeyType = map[string]int{
 
 // helpers
 
-func logHostInfo(l *zap.Logger, host libp2p_host.Host) {
+// listen binds the listeners of host. Unless bestEffort is set, it fails if
+// any of them cannot be bound, otherwise it only fails if none can.
+func listen(l *zap.Logger, host libp2p_host.Host, listeners []ma.Multiaddr, bestEffort bool) error {
+	var bound int
+	for _, addr := range listeners {
+		if err := host.Network().Listen(addr); err != nil {
+			if !bestEffort {
+				return fmt.Errorf("unable to listen on %s: %w", addr, err)
+			}
+
+			l.Warn("unable to listen", zap.Stringer("addr", addr), zap.Error(err))
+			continue
+		}
+		bound++
+	}
+
+	if bound == 0 && len(listeners) > 0 {
+		return fmt.Errorf("unable to listen on any address")
+	}
+
+	return nil
+}
+
+func logHostInfo(l *zap.Logger, host libp2p_host.Host, extra ...zapcore.Field) {
 	// print peer addrs
 	fields := []zapcore.Field{
 		zap.String("host ID (local)", host.ID().String()),
 	}
+	fields = append(fields, extra...)
 
 	addrs := host.Addrs()
 	pi := libp2p_peer.AddrInfo{
@@ -380,3 +1719,105 @@ func logHostInfo(l *zap.Logger, host libp2p_host.Host) {
 
 	l.Info("host started", fields...)
 }
+
+// emitterDriverName names the i-th emitter sync driver in the logs and
+// metrics, the first one keeps the name it had when there could be only one.
+func emitterDriverName(i int) string {
+	if i == 0 {
+		return "emitter"
+	}
+	return fmt.Sprintf("emitter-%d", i+1)
+}
+
+// secretFlags are never exposed by the /config endpoint and the inventory.
+var secretFlags = map[string]bool{
+	"pk":                true,
+	"metrics-auth-pass": true,
+	"emitter-admin-key": true,
+}
+
+// urlFlags can hold URLs, possibly with credentials in their userinfo, it is
+// stripped from the /config endpoint, the inventory and the logs.
+var urlFlags = map[string]bool{
+	"inventory-url":       true,
+	"profiling-endpoint":  true,
+	"namespace-allowlist": true,
+	"emitter-server":      true,
+}
+
+// stripUserinfo removes the userinfo (user:pass@) of the URLs of the comma
+// separated value, the other items are kept as is.
+func stripUserinfo(value string) string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		if u, err := url.Parse(item); err == nil && u.User != nil {
+			u.User = nil
+			items[i] = u.String()
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// configHandler exposes the resolved flags of fs as JSON, secrets are redacted.
+func configHandler(fs *flag.FlagSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, redactedConfig(fs))
+	})
+}
+
+// redactedConfig returns the resolved flags of fs, secrets are redacted.
+func redactedConfig(fs *flag.FlagSet) map[string]string {
+	cfg := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch {
+		case secretFlags[f.Name] && value != "":
+			value = "<redacted>"
+		case urlFlags[f.Name]:
+			value = stripUserinfo(value)
+		}
+		cfg[f.Name] = value
+	})
+	return cfg
+}
+
+// metricsShutdownTimeout bounds the wait for the in-flight scrapes on
+// shutdown.
+const metricsShutdownTimeout = 5 * time.Second
+
+// shutdownHTTPServer stops server gracefully, waiting up to timeout for the
+// in-flight requests, then closes it.
+func shutdownHTTPServer(l *zap.Logger, server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		l.Debug("http server graceful shutdown failed, closing it", zap.Error(err))
+		server.Close()
+		return
+	}
+
+	l.Debug("http server gracefully shut down")
+}
+
+// logShutdown logs a single entry describing why the server is shutting down.
+func logShutdown(l *zap.Logger, uptime time.Duration, err error, cause error) {
+	fields := []zapcore.Field{
+		zap.Duration("uptime", uptime),
+	}
+
+	var sigErr run.SignalError
+	switch {
+	case err != nil && !errors.Is(err, context.Canceled):
+		fields = append(fields, zap.String("reason", "fatal error"), zap.Error(err))
+	case errors.As(cause, &sigErr):
+		fields = append(fields, zap.String("reason", "signal"), zap.Stringer("signal", sigErr.Signal))
+	default:
+		fields = append(fields, zap.String("reason", "context canceled"))
+		if cause != nil && !errors.Is(cause, context.Canceled) {
+			fields = append(fields, zap.NamedError("cause", cause))
+		}
+	}
+
+	l.Info("shutting down", fields...)
+}
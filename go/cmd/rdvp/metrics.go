@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"berty.tech/berty/v2/go/pkg/bertyversion"
+)
+
+const metricsNamespace = "rdvp"
+
+// rdvpMetrics holds the rdvp specific metrics, it can be registered as a
+// single prometheus collector.
+type rdvpMetrics struct {
+	collectors []prometheus.Collector
+	startedAt  time.Time
+
+	buildInfo             *prometheus.GaugeVec
+	dependencyUp          *prometheus.GaugeVec
+	namespaceNotAllowed   *prometheus.CounterVec
+	registrationRejected  *prometheus.CounterVec
+	registrationAddrTypes *prometheus.CounterVec
+	discoverRejected      *prometheus.CounterVec
+
+	registrationsAccepted  prometheus.Counter
+	discoverQueries        prometheus.Counter
+	registrationsAugmented prometheus.Counter
+	idleConnsClosed        prometheus.Counter
+	identifyTimeouts       prometheus.Counter
+	discoverTruncated      prometheus.Counter
+	connsEvicted           prometheus.Counter
+	expireSweepDeleted     prometheus.Counter
+	expireSweepDuration    prometheus.Histogram
+	discoveryCacheHits     prometheus.Counter
+	discoveryCacheMisses   prometheus.Counter
+	quicStatelessResets    prometheus.Counter
+	dbWritesRejected       prometheus.Counter
+
+	syncDriverErrors   *prometheus.CounterVec
+	shadowDBErrors     *prometheus.CounterVec
+	shadowDBMismatches *prometheus.CounterVec
+	connsRejected      *prometheus.CounterVec
+	shardReferrals     *prometheus.CounterVec
+	protocolRequests   *prometheus.CounterVec
+	deadlineResets     *prometheus.CounterVec
+	handlerErrors      *prometheus.CounterVec
+
+	allowlistLastRefresh prometheus.Gauge
+	dbWriteQueue         prometheus.Gauge
+	parentRegistered     prometheus.Gauge
+}
+
+func newRdvpMetrics() *rdvpMetrics {
+	m := &rdvpMetrics{startedAt: time.Now()}
+
+	m.collectors = append(m.collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "uptime_seconds",
+		Help:      "time elapsed since the node started",
+	}, func() float64 { return m.uptime().Seconds() }))
+
+	m.buildInfo = m.gaugeVec(prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "rdvp build and deployment information, always 1",
+	}, "version", "vcs_ref", "deployment_id")
+
+	m.dependencyUp = m.gaugeVec(prometheus.GaugeOpts{
+		Name: "dependency_up",
+		Help: "whether a configured dependency is reachable (1) or not (0), by dependency",
+	}, "dependency")
+
+	m.namespaceNotAllowed = m.counterVec(prometheus.CounterOpts{
+		Name: "namespace_not_allowed_total",
+		Help: "operations rejected because their namespace is not in the allowlist",
+	}, "operation")
+
+	m.registrationRejected = m.counterVec(prometheus.CounterOpts{
+		Name: "registration_rejected_total",
+		Help: "registrations rejected, by the policy rejecting them",
+	}, "policy")
+
+	m.discoverRejected = m.counterVec(prometheus.CounterOpts{
+		Name: "discover_rejected_total",
+		Help: "discover queries rejected, by reason (rate: -discover-rate exceeded)",
+	}, "reason")
+
+	m.registrationAddrTypes = m.counterVec(prometheus.CounterOpts{
+		Name: "registration_addr_type_total",
+		Help: "addresses of the accepted registrations, by transport and scope",
+	}, "type")
+
+	m.registrationsAccepted = m.counter(prometheus.CounterOpts{
+		Name: "registrations_total",
+		Help: "registrations accepted, including the refreshes",
+	})
+
+	m.discoverQueries = m.counter(prometheus.CounterOpts{
+		Name: "discover_queries_total",
+		Help: "discovery queries served",
+	})
+
+	m.registrationsAugmented = m.counter(prometheus.CounterOpts{
+		Name: "registrations_augmented_total",
+		Help: "registrations augmented with the observed public address of the registrant",
+	})
+
+	m.idleConnsClosed = m.counter(prometheus.CounterOpts{
+		Name: "idle_connections_closed_total",
+		Help: "connections closed after being idle longer than the peer idle timeout",
+	})
+
+	m.identifyTimeouts = m.counter(prometheus.CounterOpts{
+		Name: "identify_timeouts_total",
+		Help: "connections closed because identify did not complete within the identify timeout",
+	})
+
+	m.discoverTruncated = m.counter(prometheus.CounterOpts{
+		Name: "discover_responses_truncated_total",
+		Help: "discovery responses truncated to fit the maximum response size",
+	})
+
+	m.connsEvicted = m.counter(prometheus.CounterOpts{
+		Name: "connections_evicted_total",
+		Help: "unprotected connections closed to make room for a protected peer",
+	})
+
+	m.expireSweepDeleted = m.counter(prometheus.CounterOpts{
+		Name: "expire_sweep_deleted_total",
+		Help: "expired registrations deleted by the expiry sweep (-gc-interval)",
+	})
+
+	m.expireSweepDuration = m.histogram(prometheus.HistogramOpts{
+		Name:    "expire_sweep_duration_seconds",
+		Help:    "duration of the expiry sweeps",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	m.discoveryCacheHits = m.counter(prometheus.CounterOpts{
+		Name: "discovery_cache_hits_total",
+		Help: "discoveries served from the discovery cache",
+	})
+
+	m.discoveryCacheMisses = m.counter(prometheus.CounterOpts{
+		Name: "discovery_cache_misses_total",
+		Help: "cacheable discoveries not found in the discovery cache",
+	})
+
+	m.quicStatelessResets = m.counter(prometheus.CounterOpts{
+		Name: "quic_stateless_resets_total",
+		Help: "rendezvous streams interrupted by a QUIC stateless reset of their connection",
+	})
+
+	m.syncDriverErrors = m.counterVec(prometheus.CounterOpts{
+		Name: "sync_driver_errors_total",
+		Help: "errors logged by the sync drivers, by driver and error message",
+	}, "driver", "error")
+
+	m.handlerErrors = m.counterVec(prometheus.CounterOpts{
+		Name: "handler_errors_total",
+		Help: "internal errors of the rendezvous handlers, by operation, the ones caused by the shutdown are not counted",
+	}, "op")
+
+	m.shadowDBErrors = m.counterVec(prometheus.CounterOpts{
+		Name: "shadow_db_errors_total",
+		Help: "operations that failed on the shadow db only, by operation",
+	}, "op")
+
+	m.shadowDBMismatches = m.counterVec(prometheus.CounterOpts{
+		Name: "shadow_db_mismatches_total",
+		Help: "sampled reads whose shadow db result differs from the primary one, by operation",
+	}, "op")
+
+	m.connsRejected = m.counterVec(prometheus.CounterOpts{
+		Name: "connections_rejected_total",
+		Help: "inbound connections rejected by the connection gater, by reason",
+	}, "reason")
+
+	m.shardReferrals = m.counterVec(prometheus.CounterOpts{
+		Name: "shard_referrals_total",
+		Help: "operations referred to the node owning their namespace, by operation",
+	}, "operation")
+
+	m.protocolRequests = m.counterVec(prometheus.CounterOpts{
+		Name: "protocol_requests_total",
+		Help: "rendezvous requests received, by protocol ID",
+	}, "protocol")
+
+	m.deadlineResets = m.counterVec(prometheus.CounterOpts{
+		Name: "stream_deadline_resets_total",
+		Help: "rendezvous streams reset because a read or write exceeded its deadline, by phase",
+	}, "phase")
+
+	m.dbWritesRejected = m.counter(prometheus.CounterOpts{
+		Name: "db_writes_rejected_total",
+		Help: "registrations and unregistrations rejected because too many were waiting for the db (-max-concurrent-writes)",
+	})
+
+	m.dbWriteQueue = m.gauge(prometheus.GaugeOpts{
+		Name: "db_write_queue_depth",
+		Help: "registrations and unregistrations waiting for a db write slot (-max-concurrent-writes)",
+	})
+
+	m.parentRegistered = m.gauge(prometheus.GaugeOpts{
+		Name: "parent_registered",
+		Help: "1 if the node is registered at its -parent-rdvp, 0 otherwise",
+	})
+
+	m.allowlistLastRefresh = m.gauge(prometheus.GaugeOpts{
+		Name: "namespace_allowlist_last_refresh_timestamp_seconds",
+		Help: "time of the last successful fetch of the remote namespace allowlist",
+	})
+
+	return m
+}
+
+// observeRegistrations exports gauges computed from idx.
+func (m *rdvpMetrics) observeRegistrations(idx *registrationIndex) {
+	m.collectors = append(m.collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "oldest_registration_age_seconds",
+		Help:      "age of the oldest active registration, the ones made before the node started are assumed made with the maximum ttl",
+	}, func() float64 {
+		oldest, ok := idx.oldest()
+		if !ok {
+			return 0
+		}
+		return time.Since(oldest).Seconds()
+	}))
+}
+
+// observeActiveRegistrations exports the number of active registrations,
+// in total and for the n namespaces with the most of them, queried at each
+// scrape.
+func (m *rdvpMetrics) observeActiveRegistrations(count countRegistrationsFunc, top topNamespacesFunc, n int) {
+	m.collectors = append(m.collectors, &activeRegistrationsCollector{
+		count: count,
+		top:   top,
+		n:     n,
+		total: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "active_registrations"),
+			"active registrations in the db",
+			nil, nil,
+		),
+		byNamespace: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "active_registrations_by_namespace"),
+			"active registrations of the namespaces with the most of them",
+			[]string{"namespace"}, nil,
+		),
+	})
+}
+
+// observeRelayPeerBytes exports the relayed bytes of the biggest
+// consumers of the last complete window of b.
+func (m *rdvpMetrics) observeRelayPeerBytes(b *relayPeerBandwidth) {
+	m.collectors = append(m.collectors, &relayPeerBytesCollector{
+		bandwidth: b,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "relay_bytes"),
+			"bytes relayed (both directions) to the reserving peers consuming the most, over the last complete window",
+			[]string{"peer"}, nil,
+		),
+	})
+}
+
+// observeTransportBytes exports the stream traffic of t by transport.
+func (m *rdvpMetrics) observeTransportBytes(t *transportBandwidth) {
+	m.collectors = append(m.collectors, &transportBytesCollector{
+		bandwidth: t,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "transport_bytes_total"),
+			"stream bytes by transport (tcp, quic, websocket, webtransport, circuit, relay for the relay service streams, unknown) and direction",
+			[]string{"transport", "direction"}, nil,
+		),
+	})
+}
+
+type transportBytesCollector struct {
+	bandwidth *transportBandwidth
+	desc      *prometheus.Desc
+}
+
+func (c *transportBytesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *transportBytesCollector) Collect(ch chan<- prometheus.Metric) {
+	for transport, stats := range c.bandwidth.totals() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(stats.In), transport, "in")
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(stats.Out), transport, "out")
+	}
+}
+
+type relayPeerBytesCollector struct {
+	bandwidth *relayPeerBandwidth
+	desc      *prometheus.Desc
+}
+
+func (c *relayPeerBytesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *relayPeerBytesCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, pb := range c.bandwidth.top() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(pb.Bytes), pb.Peer.String())
+	}
+}
+
+// activeRegistrationsScrapeTimeout bounds the db queries of a scrape.
+const activeRegistrationsScrapeTimeout = 5 * time.Second
+
+type activeRegistrationsCollector struct {
+	count countRegistrationsFunc
+	top   topNamespacesFunc
+	n     int
+
+	total       *prometheus.Desc
+	byNamespace *prometheus.Desc
+}
+
+func (c *activeRegistrationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+	ch <- c.byNamespace
+}
+
+func (c *activeRegistrationsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), activeRegistrationsScrapeTimeout)
+	defer cancel()
+
+	count, err := c.count(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.total, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(count))
+
+	top, err := c.top(ctx, c.n)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.byNamespace, err)
+		return
+	}
+	for _, nc := range top {
+		ch <- prometheus.MustNewConstMetric(c.byNamespace, prometheus.GaugeValue, float64(nc.Count), nc.Namespace)
+	}
+}
+
+// observeConnections exports the number of open connections of n.
+func (m *rdvpMetrics) observeConnections(n libp2p_network.Network) {
+	m.collectors = append(m.collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "open_connections",
+		Help:      "open connections, see -conn-low and -conn-high",
+	}, func() float64 { return float64(len(n.Conns())) }))
+}
+
+// observeStreamsPerConn exports the distribution of the number of open
+// streams per connection of n, sampled at each scrape.
+func (m *rdvpMetrics) observeStreamsPerConn(n libp2p_network.Network) {
+	m.collectors = append(m.collectors, &streamsPerConnCollector{
+		network: n,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "streams_per_conn"),
+			"open streams per connection, sampled at scrape time",
+			nil, nil,
+		),
+	})
+}
+
+type streamsPerConnCollector struct {
+	network libp2p_network.Network
+	desc    *prometheus.Desc
+}
+
+var streamsPerConnBuckets = prometheus.ExponentialBuckets(1, 2, 10)
+
+func (c *streamsPerConnCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *streamsPerConnCollector) Collect(ch chan<- prometheus.Metric) {
+	var (
+		count   uint64
+		sum     float64
+		buckets = make(map[float64]uint64, len(streamsPerConnBuckets))
+	)
+
+	for _, conn := range c.network.Conns() {
+		streams := float64(len(conn.GetStreams()))
+		count++
+		sum += streams
+		for _, b := range streamsPerConnBuckets {
+			if streams <= b {
+				buckets[b]++
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstHistogram(c.desc, count, sum, buckets)
+}
+
+func (m *rdvpMetrics) uptime() time.Duration {
+	return time.Since(m.startedAt)
+}
+
+func (m *rdvpMetrics) setDeploymentID(id string) {
+	m.buildInfo.WithLabelValues(bertyversion.Version, bertyversion.VcsRef, id).Set(1)
+}
+
+func (m *rdvpMetrics) gaugeVec(opts prometheus.GaugeOpts, labels ...string) *prometheus.GaugeVec {
+	opts.Namespace = metricsNamespace
+	g := prometheus.NewGaugeVec(opts, labels)
+	m.collectors = append(m.collectors, g)
+	return g
+}
+
+func (m *rdvpMetrics) gauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	opts.Namespace = metricsNamespace
+	g := prometheus.NewGauge(opts)
+	m.collectors = append(m.collectors, g)
+	return g
+}
+
+func (m *rdvpMetrics) counter(opts prometheus.CounterOpts) prometheus.Counter {
+	opts.Namespace = metricsNamespace
+	c := prometheus.NewCounter(opts)
+	m.collectors = append(m.collectors, c)
+	return c
+}
+
+func (m *rdvpMetrics) histogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	opts.Namespace = metricsNamespace
+	h := prometheus.NewHistogram(opts)
+	m.collectors = append(m.collectors, h)
+	return h
+}
+
+func (m *rdvpMetrics) counterVec(opts prometheus.CounterOpts, labels ...string) *prometheus.CounterVec {
+	opts.Namespace = metricsNamespace
+	c := prometheus.NewCounterVec(opts, labels)
+	m.collectors = append(m.collectors, c)
+	return c
+}
+
+// writeMetricsTextfile writes the metrics gathered from g to path every
+// interval until ctx is done, the file is replaced atomically so a reader
+// never sees a partial write.
+func writeMetricsTextfile(ctx context.Context, logger *zap.Logger, g prometheus.Gatherer, path string, interval time.Duration) error {
+	for {
+		if err := prometheus.WriteToTextfile(path, g); err != nil {
+			logger.Warn("unable to write the metrics textfile", zap.String("path", path), zap.Error(err))
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// validateMetricsPath checks the -metrics-path, it can't be one of the other
+// endpoints of the metrics listener.
+func validateMetricsPath(path string) error {
+	switch {
+	case !strings.HasPrefix(path, "/"):
+		return fmt.Errorf("invalid metrics path `%s`: must start with /", path)
+	case path == "/config" || path == "/healthz" || path == "/readyz":
+		return fmt.Errorf("invalid metrics path `%s`: already used by the metrics listener", path)
+	}
+	return nil
+}
+
+// metricsWarmupHandler answers 503 until the node has been up for warmup,
+// then defers to next.
+func metricsWarmupHandler(m *rdvpMetrics, warmup time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if left := warmup - m.uptime(); left > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// minimalGoCollectors is a lightweight replacement of the prometheus Go
+// collector, for resource constrained nodes.
+func minimalGoCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "heap_inuse_bytes",
+			Help:      "bytes in in-use heap spans",
+		}, func() float64 {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			return float64(ms.HeapInuse)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "goroutines",
+			Help:      "number of goroutines that currently exist",
+		}, func() float64 { return float64(runtime.NumGoroutine()) }),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *rdvpMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *rdvpMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors {
+		c.Collect(ch)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	libp2p_mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStreamsPerConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := libp2p_mocknet.FullMeshConnected(2)
+	require.NoError(t, err)
+	defer mn.Close()
+
+	a, b := mn.Hosts()[0], mn.Hosts()[1]
+	b.SetStreamHandler("/test", func(s libp2p_network.Stream) {})
+
+	for i := 0; i < 3; i++ {
+		s, err := a.NewStream(ctx, b.ID(), "/test")
+		require.NoError(t, err)
+		defer s.Close()
+	}
+
+	metrics := newRdvpMetrics()
+	metrics.observeStreamsPerConn(a.Network())
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != "rdvp_streams_per_conn" {
+			continue
+		}
+
+		h := family.GetMetric()[0].GetHistogram()
+		assert.Equal(t, uint64(1), h.GetSampleCount())
+		// plus the streams of the host services (identify, ...)
+		assert.GreaterOrEqual(t, h.GetSampleSum(), 3.0)
+		return
+	}
+	t.Fatal("rdvp_streams_per_conn not exported")
+}
+
+func TestMetricsWarmupHandler(t *testing.T) {
+	metrics := newRdvpMetrics()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	metricsWarmupHandler(metrics, time.Hour, next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	metrics.startedAt = time.Now().Add(-time.Hour)
+	rec = httptest.NewRecorder()
+	metricsWarmupHandler(metrics, time.Hour, next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestWriteMetricsTextfile(t *testing.T) {
+	metrics := newRdvpMetrics()
+	metrics.registrationsAccepted.Inc()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	path := filepath.Join(t.TempDir(), "rdvp.prom")
+	err := writeMetricsTextfile(ctx, zap.NewNop(), registry, path, time.Hour)
+	require.ErrorIs(t, err, context.Canceled)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "rdvp_registrations_total 1\n")
+}
+
+func TestActiveRegistrations(t *testing.T) {
+	idx := newRegistrationIndex()
+	for i, ns := range []string{"a", "a", "b", "c"} {
+		idx.add(libp2p_peer.ID(fmt.Sprintf("peer-%d", i)), ns, 60)
+	}
+
+	metrics := newRdvpMetrics()
+	metrics.observeActiveRegistrations(idx.count, idx.topNamespaces, 2)
+
+	expected := `
+# HELP rdvp_active_registrations active registrations in the db
+# TYPE rdvp_active_registrations gauge
+rdvp_active_registrations 4
+# HELP rdvp_active_registrations_by_namespace active registrations of the namespaces with the most of them
+# TYPE rdvp_active_registrations_by_namespace gauge
+rdvp_active_registrations_by_namespace{namespace="a"} 2
+rdvp_active_registrations_by_namespace{namespace="b"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(metrics, strings.NewReader(expected),
+		"rdvp_active_registrations", "rdvp_active_registrations_by_namespace"))
+}
+
+func TestValidateMetricsPath(t *testing.T) {
+	assert.NoError(t, validateMetricsPath("/metrics"))
+	assert.NoError(t, validateMetricsPath("/v1/metrics"))
+	assert.Error(t, validateMetricsPath("metrics"))
+	assert.Error(t, validateMetricsPath(""))
+	assert.Error(t, validateMetricsPath("/healthz"))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// metricsAuthRealm is the realm of the basic auth challenge of the metrics
+// listener.
+const metricsAuthRealm = "rdvp metrics"
+
+func validateMetricsAuth(user, pass string) error {
+	if (user == "") != (pass == "") {
+		return fmt.Errorf("-metrics-auth-user and -metrics-auth-pass must be set together")
+	}
+	return nil
+}
+
+// basicAuthHandler requires the user and pass credentials on next, the
+// requests without them get a 401 with a basic auth challenge.
+func basicAuthHandler(user, pass string, next http.Handler) http.Handler {
+	// comparing the hashes keeps the comparison constant-time whatever the
+	// length of the credentials sent
+	wantUser, wantPass := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if ok {
+			gotUser, gotPass := sha256.Sum256([]byte(u)), sha256.Sum256([]byte(p))
+			userOK := subtle.ConstantTimeCompare(gotUser[:], wantUser[:])
+			passOK := subtle.ConstantTimeCompare(gotPass[:], wantPass[:])
+			if userOK&passOK == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+metricsAuthRealm+`", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicAuthHandler(t *testing.T) {
+	require.NoError(t, validateMetricsAuth("", ""))
+	require.NoError(t, validateMetricsAuth("scraper", "secret"))
+	require.Error(t, validateMetricsAuth("scraper", ""))
+	require.Error(t, validateMetricsAuth("", "secret"))
+
+	handler := basicAuthHandler("scraper", "secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		user, pass string
+		auth       bool
+		status     int
+	}{
+		{"scraper", "secret", true, http.StatusOK},
+		{"scraper", "wrong", true, http.StatusUnauthorized},
+		{"other", "secret", true, http.StatusUnauthorized},
+		{"", "", false, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.auth {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.status, rec.Code, tc.user+":"+tc.pass)
+		if tc.status == http.StatusUnauthorized {
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic realm=")
+		}
+	}
+}
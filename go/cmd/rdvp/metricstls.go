@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// metricsTLSConfig returns the TLS config of the metrics listener, or nil if
+// neither certFile nor keyFile is set. If clientCA is set, the scrapers must
+// present a certificate signed by one of its CAs.
+func metricsTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	switch {
+	case certFile == "" && keyFile == "":
+		if clientCA != "" {
+			return nil, fmt.Errorf("-metrics-tls-client-ca requires -metrics-tls-cert and -metrics-tls-key")
+		}
+		return nil, nil
+	case certFile == "" || keyFile == "":
+		return nil, fmt.Errorf("-metrics-tls-cert and -metrics-tls-key must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the metrics certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in `%s`", clientCA)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rdvp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0o600))
+	return certFile, keyFile
+}
+
+func TestMetricsTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	config, err := metricsTLSConfig("", "", "")
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	_, err = metricsTLSConfig(certFile, "", "")
+	require.Error(t, err)
+	_, err = metricsTLSConfig("", keyFile, "")
+	require.Error(t, err)
+	_, err = metricsTLSConfig("", "", certFile)
+	require.Error(t, err)
+	_, err = metricsTLSConfig(certFile, certFile, "")
+	require.Error(t, err)
+
+	config, err = metricsTLSConfig(certFile, keyFile, "")
+	require.NoError(t, err)
+	require.Len(t, config.Certificates, 1)
+	require.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	config, err = metricsTLSConfig(certFile, keyFile, certFile)
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	_, err = metricsTLSConfig(certFile, keyFile, keyFile)
+	require.Error(t, err)
+}
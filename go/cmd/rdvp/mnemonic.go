@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed" // bip39_english.txt
+	"fmt"
+	"os"
+	"strings"
+
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	mnemonicSeedIterations = 2048
+	mnemonicSeedLength     = 64
+
+	// mnemonicEntropyLength is the entropy of the phrases generated by
+	// `rdvp genkey -mnemonic`, in bytes (24 words)
+	mnemonicEntropyLength = 32
+)
+
+// bip39English is the BIP39 English wordlist, one word per line.
+//
+//go:embed bip39_english.txt
+var bip39English string
+
+var (
+	bip39Words = strings.Fields(bip39English)
+	bip39Index = func() map[string]int {
+		index := make(map[string]int, len(bip39Words))
+		for i, word := range bip39Words {
+			index[word] = i
+		}
+		return index
+	}()
+)
+
+// newMnemonic returns the BIP39 phrase encoding entropy (16 to 32 bytes, by
+// steps of 4): the entropy followed by a checksum of its first bits of
+// SHA-256, 11 bits per word.
+func newMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("invalid entropy length %d, must be 16 to 32 bytes by steps of 4", len(entropy))
+	}
+
+	checksum := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), checksum[0])
+	nwords := (len(entropy)*8 + len(entropy)/4) / 11
+
+	words := make([]string, nwords)
+	for i := range words {
+		index := 0
+		for bit := i * 11; bit < (i+1)*11; bit++ {
+			index = index<<1 | int(data[bit/8]>>(7-bit%8)&1)
+		}
+		words[i] = bip39Words[index]
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// checkMnemonic checks that words are in the BIP39 wordlist and that their
+// checksum is valid, so a typo doesn't silently give another key.
+func checkMnemonic(words []string) error {
+	data := make([]byte, (len(words)*11+7)/8)
+	for i, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			return fmt.Errorf("invalid recovery phrase: %q (word %d) is not in the BIP39 wordlist", word, i+1)
+		}
+		for b := 0; b < 11; b++ {
+			if index>>(10-b)&1 == 1 {
+				bit := i*11 + b
+				data[bit/8] |= 1 << (7 - bit%8)
+			}
+		}
+	}
+
+	// 1 checksum bit per 32 bits of entropy
+	entropyLen := len(words) * 11 * 32 / 33 / 8
+	phrase, err := newMnemonic(data[:entropyLen])
+	if err != nil {
+		return err
+	}
+	if phrase != strings.Join(words, " ") {
+		return fmt.Errorf("invalid recovery phrase: wrong checksum, check the words")
+	}
+	return nil
+}
+
+// keyFromMnemonic deterministically derives an Ed25519 private key from a
+// BIP39 recovery phrase.
+//
+// The phrase is turned into a seed the BIP39 way (PBKDF2-HMAC-SHA512 of the
+// NFKD normalized phrase, salted with "mnemonic" and no passphrase), the
+// first 32 bytes of the seed are used as the Ed25519 private key seed.
+// The phrase is the private key: anyone knowing it can impersonate the node.
+func keyFromMnemonic(phrase string) (libp2p_ci.PrivKey, error) {
+	words := strings.Fields(norm.NFKD.String(phrase))
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("invalid recovery phrase: expected 12, 15, 18, 21 or 24 words, got %d", len(words))
+	}
+
+	if err := checkMnemonic(words); err != nil {
+		return nil, err
+	}
+
+	seed := pbkdf2.Key([]byte(strings.Join(words, " ")), []byte("mnemonic"), mnemonicSeedIterations, mnemonicSeedLength, sha512.New)
+	priv, _, err := libp2p_ci.GenerateEd25519Key(bytes.NewReader(seed[:32]))
+	return priv, err
+}
+
+// keyFromMnemonicFile derives the private key from the recovery phrase
+// stored in path, see keyFromMnemonic.
+func keyFromMnemonicFile(path string) (libp2p_ci.PrivKey, error) {
+	phrase, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return keyFromMnemonic(string(phrase))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMnemonic(t *testing.T) {
+	require.Len(t, bip39Words, 2048)
+
+	// BIP39 test vectors
+	for entropy, phrase := range map[string]string{
+		"00000000000000000000000000000000":                                 strings.Repeat("abandon ", 11) + "about",
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f":                                 "legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"80808080808080808080808080808080":                                 "letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"ffffffffffffffffffffffffffffffff":                                 strings.Repeat("zoo ", 11) + "wrong",
+		"9e885d952ad362caeb4efe34a8e91bd2":                                 "ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic",
+		"0000000000000000000000000000000000000000000000000000000000000000": strings.Repeat("abandon ", 23) + "art",
+	} {
+		b, err := hex.DecodeString(entropy)
+		require.NoError(t, err)
+
+		got, err := newMnemonic(b)
+		require.NoError(t, err)
+		assert.Equal(t, phrase, got)
+		assert.NoError(t, checkMnemonic(strings.Fields(phrase)))
+	}
+
+	_, err := newMnemonic(make([]byte, 15))
+	assert.Error(t, err)
+}
+
+func TestKeyFromMnemonic(t *testing.T) {
+	const phrase = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+	priv, err := keyFromMnemonic(phrase)
+	require.NoError(t, err)
+
+	// extra whitespaces are not significant
+	same, err := keyFromMnemonic("  abandon abandon abandon abandon abandon abandon\n abandon abandon abandon abandon abandon about ")
+	require.NoError(t, err)
+	assert.True(t, priv.Equals(same))
+
+	id, err := libp2p_peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	sameID, err := libp2p_peer.IDFromPrivateKey(same)
+	require.NoError(t, err)
+	assert.Equal(t, id, sameID)
+
+	other, err := keyFromMnemonic("zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong")
+	require.NoError(t, err)
+	assert.False(t, priv.Equals(other))
+
+	_, err = keyFromMnemonic("abandon about")
+	assert.Error(t, err)
+
+	// wrong checksum
+	_, err = keyFromMnemonic(strings.Repeat("abandon ", 12))
+	assert.Error(t, err)
+	// typo
+	_, err = keyFromMnemonic(strings.Repeat("abandon ", 11) + "abuot")
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "phrase")
+	require.NoError(t, os.WriteFile(path, []byte(phrase+"\n"), 0o600))
+	fromFile, err := keyFromMnemonicFile(path)
+	require.NoError(t, err)
+	assert.True(t, priv.Equals(fromFile))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// monitorMetrics are exported by the monitor command, so it can be used as a
+// blackbox exporter of a remote rdvp.
+type monitorMetrics struct {
+	up       prometheus.Gauge
+	checks   *prometheus.CounterVec
+	duration prometheus.Histogram
+}
+
+func newMonitorMetrics(target libp2p_peer.ID) *monitorMetrics {
+	labels := prometheus.Labels{"target": target.String()}
+	return &monitorMetrics{
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   "monitor",
+			Name:        "up",
+			Help:        "1 if the last self-test of the target succeeded, 0 otherwise",
+			ConstLabels: labels,
+		}),
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   "monitor",
+			Name:        "checks_total",
+			Help:        "self-tests run against the target, by result",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   "monitor",
+			Name:        "check_duration_seconds",
+			Help:        "duration of the self-tests run against the target",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
+	}
+}
+
+func (m *monitorMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.up, m.checks, m.duration}
+}
+
+// validateMonitorOptions checks the monitor flags: a self-test needs a
+// positive timeout, and the ticker a positive interval.
+func validateMonitorOptions(interval, timeout time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("-interval must be positive, got %s", interval)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("-timeout must be positive, got %s", timeout)
+	}
+	return nil
+}
+
+// runMonitor runs a register+discover self-test against target every interval,
+// and prints one status line per test to out.
+func runMonitor(ctx context.Context, out io.Writer, client libp2p_host.Host, target libp2p_peer.AddrInfo, interval, timeout time.Duration, metrics *monitorMetrics) error {
+	var ok, failed int
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := rendezvousSelfTest(checkCtx, client, target)
+		latency := time.Since(start)
+		cancel()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		metrics.duration.Observe(latency.Seconds())
+		if err != nil {
+			failed++
+			metrics.up.Set(0)
+			metrics.checks.WithLabelValues("failure").Inc()
+			fmt.Fprintf(out, "%s FAIL %-10s ok=%d failed=%d: %s\n", start.Format(time.RFC3339), latency.Round(time.Millisecond), ok, failed, err)
+		} else {
+			ok++
+			metrics.up.Set(1)
+			metrics.checks.WithLabelValues("success").Inc()
+			fmt.Fprintf(out, "%s OK   %-10s ok=%d failed=%d\n", start.Format(time.RFC3339), latency.Round(time.Millisecond), ok, failed)
+		}
+
+		// drop the connection, so each test measures a full connection
+		// establishment
+		_ = client.Network().ClosePeer(target.ID)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	"github.com/libp2p/go-libp2p"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMonitorOptions(t *testing.T) {
+	assert.NoError(t, validateMonitorOptions(10*time.Second, 5*time.Second))
+	assert.Error(t, validateMonitorOptions(0, 5*time.Second))
+	assert.Error(t, validateMonitorOptions(-time.Second, 5*time.Second))
+	assert.Error(t, validateMonitorOptions(10*time.Second, 0))
+	assert.Error(t, validateMonitorOptions(10*time.Second, -time.Second))
+}
+
+func TestRunMonitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+	_ = newService(server, db, serviceOptions{Metrics: newRdvpMetrics()})
+
+	client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	var out bytes.Buffer
+	metrics := newMonitorMetrics(server.ID())
+	done := make(chan error)
+	go func() {
+		done <- runMonitor(ctx, &out, client, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}, 10*time.Millisecond, 5*time.Second, metrics)
+	}()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.checks.WithLabelValues("success")) >= 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.up))
+	assert.Zero(t, testutil.ToFloat64(metrics.checks.WithLabelValues("failure")))
+	assert.Contains(t, strings.SplitN(out.String(), "\n", 2)[0], " OK ")
+}
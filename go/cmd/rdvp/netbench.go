@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p "github.com/libp2p/go-libp2p"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// netBenchOpTimeout bounds each operation of the network benchmark.
+	netBenchOpTimeout = 10 * time.Second
+
+	// netBenchTTL is the ttl of the benchmark registrations, in seconds,
+	// they expire soon if the final unregistration is lost.
+	netBenchTTL = 120
+)
+
+type netBenchOptions struct {
+	Concurrency int
+	Namespace   string
+	Duration    time.Duration
+}
+
+// netBenchResult holds the latencies and the errors of the operations of a
+// kind, Elapsed is the duration of the benchmark.
+type netBenchResult struct {
+	dbBenchResult
+	Errors    int
+	LastError error
+}
+
+// netBenchWorker is an ephemeral client of the benchmark.
+type netBenchWorker struct {
+	register, discover netBenchResult
+}
+
+// runNetBench runs opts.Concurrency clients, each with its own host and
+// identity, which register on opts.Namespace of target then discover it,
+// in a loop, for opts.Duration.
+func runNetBench(ctx context.Context, target libp2p_peer.AddrInfo, opts netBenchOptions) ([]*netBenchResult, error) {
+	if opts.Concurrency <= 0 || opts.Duration <= 0 || opts.Namespace == "" {
+		return nil, fmt.Errorf("the benchmark needs a concurrency, a duration and a namespace")
+	}
+
+	workers := make([]*netBenchWorker, opts.Concurrency)
+	errs := make([]error, opts.Concurrency)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = &netBenchWorker{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = workers[i].run(ctx, target, opts.Namespace)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var (
+		register = &netBenchResult{dbBenchResult: dbBenchResult{Op: "register", Elapsed: elapsed}}
+		discover = &netBenchResult{dbBenchResult: dbBenchResult{Op: "discover", Elapsed: elapsed}}
+	)
+	for i, w := range workers {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		register.merge(&w.register)
+		discover.merge(&w.discover)
+	}
+
+	results := []*netBenchResult{register, discover}
+	for _, r := range results {
+		sort.Slice(r.Latencies, func(i, j int) bool { return r.Latencies[i] < r.Latencies[j] })
+	}
+
+	return results, nil
+}
+
+func (w *netBenchWorker) run(ctx context.Context, target libp2p_peer.AddrInfo, ns string) error {
+	priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return err
+	}
+
+	client, err := libp2p.New(
+		libp2p.Identity(priv),
+		libp2p.DisableRelay(),
+		// the registrations need addrs
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	connectCtx, cancel := context.WithTimeout(ctx, netBenchOpTimeout)
+	err = client.Connect(connectCtx, target)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("unable to connect: %w", err)
+	}
+
+	rp := libp2p_rp.NewRendezvousPoint(client, target.ID)
+	defer func() {
+		// the context is done, clean up with a fresh one
+		ctx, cancel := context.WithTimeout(context.Background(), netBenchOpTimeout)
+		defer cancel()
+		netBenchUnregister(ctx, rp, client.ID(), ns)
+	}()
+
+	for ctx.Err() == nil {
+		w.register.do(ctx, func(ctx context.Context) error {
+			_, err := rp.Register(ctx, ns, netBenchTTL)
+			return err
+		})
+
+		w.discover.do(ctx, func(ctx context.Context) error {
+			_, _, err := rp.Discover(ctx, ns, 0, nil)
+			return err
+		})
+	}
+
+	return nil
+}
+
+// netBenchUnregister unregisters self from ns and waits for it to be done:
+// the unregistrations have no response, closing the client right after
+// sending one can lose it.
+func netBenchUnregister(ctx context.Context, rp libp2p_rp.RendezvousPoint, self libp2p_peer.ID, ns string) {
+	if err := rp.Unregister(ctx, ns); err != nil {
+		return
+	}
+
+	for {
+		regs, _, err := rp.Discover(ctx, ns, 0, nil)
+		if err != nil {
+			return
+		}
+
+		registered := false
+		for _, reg := range regs {
+			registered = registered || reg.Peer.ID == self
+		}
+		if !registered {
+			return
+		}
+
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// do runs op and accounts it, the operations interrupted by the end of the
+// benchmark are ignored.
+func (r *netBenchResult) do(ctx context.Context, op func(ctx context.Context) error) {
+	opCtx, cancel := context.WithTimeout(ctx, netBenchOpTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := op(opCtx)
+	switch {
+	case ctx.Err() != nil:
+	case err != nil:
+		r.Errors++
+		r.LastError = err
+	default:
+		r.observe(start)
+	}
+}
+
+func (r *netBenchResult) merge(other *netBenchResult) {
+	r.Latencies = append(r.Latencies, other.Latencies...)
+	r.Errors += other.Errors
+	if other.LastError != nil {
+		r.LastError = other.LastError
+	}
+}
+
+func printNetBenchResults(w io.Writer, results []*netBenchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\terrors\tops/s\tp50\tp95\tp99\tmax\t")
+	for _, r := range results {
+		var max time.Duration
+		if len(r.Latencies) > 0 {
+			max = r.Latencies[len(r.Latencies)-1]
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f\t%s\t%s\t%s\t%s\t\n", r.Op, len(r.Latencies), r.Errors, r.opsPerSec(),
+			r.percentile(50), r.percentile(95), r.percentile(99), max)
+	}
+	tw.Flush()
+
+	for _, r := range results {
+		if r.LastError != nil {
+			fmt.Fprintf(w, "%s: %d errors, last one: %s\n", r.Op, r.Errors, r.LastError)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	"github.com/libp2p/go-libp2p"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetBench(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+	_ = newService(server, db, serviceOptions{Metrics: newRdvpMetrics()})
+
+	target := libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}
+	results, err := runNetBench(ctx, target, netBenchOptions{Concurrency: 2, Namespace: "bench", Duration: 500 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, r := range results {
+		assert.NotEmpty(t, r.Latencies, r.Op)
+		assert.Zero(t, r.Errors, r.Op)
+	}
+
+	var out bytes.Buffer
+	printNetBenchResults(&out, results)
+	assert.Contains(t, out.String(), "p95")
+
+	// the registrations are removed at the end
+	regs, _, err := db.Discover("bench", nil, 10)
+	require.NoError(t, err)
+	assert.Empty(t, regs)
+}
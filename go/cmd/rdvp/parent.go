@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	libp2p_peerstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// parentNamespace is the namespace the nodes register in at their
+	// parent, listing the nodes of the tree
+	parentNamespace = "rdvp/nodes"
+	parentTTL       = 10 * time.Minute
+
+	parentRegisterTimeout = 30 * time.Second
+	parentMinBackoff      = 5 * time.Second
+	parentMaxBackoff      = 5 * time.Minute
+
+	parentPeerTag = "rdvp-parent"
+)
+
+// parseParent returns the parent rdvp at addr, a multiaddr including its
+// /p2p/ peer ID, it can't be self.
+func parseParent(addr string, self libp2p_peer.ID) (*libp2p_peer.AddrInfo, error) {
+	parent, err := libp2p_peer.AddrInfoFromString(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parent rdvp `%s`: %w", addr, err)
+	}
+	if parent.ID == self {
+		return nil, fmt.Errorf("invalid parent rdvp `%s`: this is the node itself", addr)
+	}
+	return parent, nil
+}
+
+// registerWithParent registers host, with its announced addrs, in ns at the
+// parent rdvp until ctx is done. The registration is refreshed at half its
+// TTL, the failed attempts are retried with an exponential backoff. up is set
+// to 1 while the node is registered, 0 otherwise.
+func registerWithParent(ctx context.Context, logger *zap.Logger, host libp2p_host.Host, parent libp2p_peer.AddrInfo, ns string, up prometheus.Gauge) error {
+	host.Peerstore().AddAddrs(parent.ID, parent.Addrs, libp2p_peerstore.PermanentAddrTTL)
+	host.ConnManager().Protect(parent.ID, parentPeerTag)
+	defer host.ConnManager().Unprotect(parent.ID, parentPeerTag)
+
+	rp := libp2p_rp.NewRendezvousPoint(host, parent.ID)
+	defer func() {
+		// best effort, the unregistrations have no response
+		ctx, cancel := context.WithTimeout(context.Background(), parentRegisterTimeout)
+		defer cancel()
+		_ = rp.Unregister(ctx, ns)
+	}()
+
+	registered := false
+	backoff := parentMinBackoff
+	for {
+		rctx, cancel := context.WithTimeout(ctx, parentRegisterTimeout)
+		ttl, err := rp.Register(rctx, ns, int(parentTTL.Seconds()))
+		cancel()
+
+		var wait time.Duration
+		switch {
+		case ctx.Err() != nil:
+			up.Set(0)
+			return ctx.Err()
+		case err != nil:
+			up.Set(0)
+			// logged once per outage, not at each retry
+			if backoff == parentMinBackoff {
+				logger.Warn("unable to register at the parent rdvp", zap.Stringer("parent", parent.ID), zap.Duration("retry in", backoff), zap.Error(err))
+			}
+			registered = false
+			wait, backoff = backoff, backoff*2
+			if backoff > parentMaxBackoff {
+				backoff = parentMaxBackoff
+			}
+		default:
+			up.Set(1)
+			if !registered {
+				logger.Info("registered at the parent rdvp", zap.Stringer("parent", parent.ID), zap.String("ns", ns), zap.Duration("ttl", ttl))
+			}
+			registered = true
+			backoff = parentMinBackoff
+			if wait = ttl / 2; wait <= 0 {
+				wait = parentTTL / 2
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			up.Set(0)
+			return ctx.Err()
+		}
+	}
+}
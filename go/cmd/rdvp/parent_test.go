@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	"github.com/libp2p/go-libp2p"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseParent(t *testing.T) {
+	const addr = "/ip4/1.2.3.4/tcp/4040/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+
+	parent, err := parseParent(addr, "")
+	require.NoError(t, err)
+	assert.Equal(t, "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ", parent.ID.String())
+
+	_, err = parseParent(addr, parent.ID)
+	assert.Error(t, err)
+
+	_, err = parseParent("/ip4/1.2.3.4/tcp/4040", "")
+	assert.Error(t, err)
+}
+
+func TestRegisterWithParent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	parent, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer parent.Close()
+	_ = newService(parent, db, serviceOptions{Metrics: newRdvpMetrics()})
+
+	child, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer child.Close()
+
+	metrics := newRdvpMetrics()
+	done := make(chan error)
+	rctx, rcancel := context.WithCancel(ctx)
+	go func() {
+		done <- registerWithParent(rctx, zap.NewNop(), child, libp2p_peer.AddrInfo{ID: parent.ID(), Addrs: parent.Addrs()}, parentNamespace, metrics.parentRegistered)
+	}()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.parentRegistered) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	regs, _, err := db.Discover(parentNamespace, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, regs, 1)
+	assert.Equal(t, child.ID(), regs[0].Id)
+
+	rcancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Zero(t, testutil.ToFloat64(metrics.parentRegistered))
+
+	// the registration is removed on exit
+	assert.Eventually(t, func() bool {
+		regs, _, err := db.Discover(parentNamespace, nil, 10)
+		return err == nil && len(regs) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
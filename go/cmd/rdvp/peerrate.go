@@ -0,0 +1,96 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// peerRateLimiterPeers bounds the number of peers tracked by a
+// peerRateLimiter, the least recently active ones are forgotten first.
+const peerRateLimiterPeers = 10000
+
+// peerRateLimiter throttles the peers with a token bucket per peer refilled
+// at rate tokens per minute, holding at most rate tokens. It limits the
+// namespaces introduced (-new-namespace-rate) and the discoveries
+// (-discover-rate) of each peer.
+type peerRateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	size  int
+
+	muBuckets sync.Mutex
+	lru       *list.List // of *peerBucket, most recent first
+	buckets   map[libp2p_peer.ID]*list.Element
+}
+
+type peerBucket struct {
+	peer   libp2p_peer.ID
+	tokens float64
+	last   time.Time
+}
+
+func newPeerRateLimiter(perMinute float64, size int) *peerRateLimiter {
+	return &peerRateLimiter{
+		rate:    perMinute / 60,
+		burst:   math.Max(1, perMinute),
+		size:    size,
+		lru:     list.New(),
+		buckets: make(map[libp2p_peer.ID]*list.Element),
+	}
+}
+
+// setRate sets the tokens per minute of the buckets and their burst, it
+// returns the previous rate. The tokens above the new burst are dropped by
+// the following allow.
+func (l *peerRateLimiter) setRate(perMinute float64) float64 {
+	l.muBuckets.Lock()
+	defer l.muBuckets.Unlock()
+
+	prev := l.rate * 60
+	l.rate = perMinute / 60
+	l.burst = math.Max(1, perMinute)
+	return prev
+}
+
+// perMinute returns the tokens per minute of the buckets.
+func (l *peerRateLimiter) perMinute() float64 {
+	l.muBuckets.Lock()
+	defer l.muBuckets.Unlock()
+
+	return l.rate * 60
+}
+
+// allow takes a token from the bucket of p, it returns false if the bucket
+// is empty.
+func (l *peerRateLimiter) allow(p libp2p_peer.ID, now time.Time) bool {
+	l.muBuckets.Lock()
+	defer l.muBuckets.Unlock()
+
+	var bucket *peerBucket
+	if elem, ok := l.buckets[p]; ok {
+		l.lru.MoveToFront(elem)
+		bucket = elem.Value.(*peerBucket)
+		bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+		bucket.last = now
+	} else {
+		bucket = &peerBucket{peer: p, tokens: l.burst, last: now}
+		l.buckets[p] = l.lru.PushFront(bucket)
+
+		for l.lru.Len() > l.size {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*peerBucket).peer)
+		}
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
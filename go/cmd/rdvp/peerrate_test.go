@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerRateLimiter(t *testing.T) {
+	l := newPeerRateLimiter(2, 2)
+	p1, p2, p3 := libp2p_peer.ID("p1"), libp2p_peer.ID("p2"), libp2p_peer.ID("p3")
+	now := time.Now()
+
+	// burst of 2, then 1 token every 30s
+	assert.True(t, l.allow(p1, now))
+	assert.True(t, l.allow(p1, now))
+	assert.False(t, l.allow(p1, now))
+	assert.False(t, l.allow(p1, now.Add(20*time.Second)))
+	assert.True(t, l.allow(p1, now.Add(31*time.Second)))
+
+	// the buckets are per peer
+	assert.True(t, l.allow(p2, now))
+
+	// p1 is the least recently active peer, it is forgotten
+	assert.True(t, l.allow(p3, now))
+	assert.Len(t, l.buckets, 2)
+	assert.NotContains(t, l.buckets, p1)
+}
+
+func TestPeerRateLimiterSetRate(t *testing.T) {
+	l := newPeerRateLimiter(4, 2)
+	p := libp2p_peer.ID("p")
+	now := time.Now()
+
+	assert.Equal(t, 4.0, l.setRate(1))
+	assert.Equal(t, 1.0, l.perMinute())
+
+	// the bucket is created with the new burst
+	assert.True(t, l.allow(p, now))
+	assert.False(t, l.allow(p, now))
+	assert.True(t, l.allow(p, now.Add(61*time.Second)))
+
+	// the tokens above the new burst are dropped
+	l.setRate(2)
+	assert.True(t, l.allow(p, now.Add(10*time.Minute)))
+	assert.True(t, l.allow(p, now.Add(10*time.Minute)))
+	assert.False(t, l.allow(p, now.Add(10*time.Minute)))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// registrationPolicy identifies the policy rejecting a registration, it is
+// used as the `policy` label of the rdvp_registration_rejected_total metric.
+type registrationPolicy string
+
+const (
+	policyNamespace          registrationPolicy = "namespace"
+	policyNamespacePattern   registrationPolicy = "namespace_pattern"
+	policyNamespaceAllowlist registrationPolicy = "namespace_allowlist"
+	policyShard              registrationPolicy = "shard"
+	policyPeerInfo           registrationPolicy = "peer_info"
+	policyTTL                registrationPolicy = "ttl"
+	policyQuota              registrationPolicy = "quota"
+	policyNewNamespaceRate   registrationPolicy = "new_namespace_rate"
+	policyWriteQueue         registrationPolicy = "write_queue"
+	policyEmptyAddrs         registrationPolicy = "empty_addrs"
+)
+
+// emptyAddrPolicy is the handling of the registrations without any usable
+// address (see usableAddr).
+type emptyAddrPolicy string
+
+const (
+	// emptyAddrReject rejects them, it is the default
+	emptyAddrReject emptyAddrPolicy = "reject"
+	// emptyAddrAugment replaces their addrs with the observed address of
+	// the registrant, if public and direct, and rejects them otherwise
+	emptyAddrAugment emptyAddrPolicy = "augment"
+	// emptyAddrAccept registers them as is
+	emptyAddrAccept emptyAddrPolicy = "accept"
+)
+
+func parseEmptyAddrPolicy(s string) (emptyAddrPolicy, error) {
+	switch p := emptyAddrPolicy(s); p {
+	case emptyAddrReject, emptyAddrAugment, emptyAddrAccept:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid empty addr policy `%s`, should be one of reject, augment or accept", s)
+	}
+}
+
+// usableAddr reports whether the registered address b may be dialed: only the
+// empty and unspecified IP addresses are unusable. Like the library, the
+// addresses this node can't decode (ie. unknown protocols) are kept as is.
+func usableAddr(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+
+	maddr, err := ma.NewMultiaddrBytes(b)
+	if err != nil {
+		return true
+	}
+	return !manet.IsIPUnspecified(maddr)
+}
+
+// namespaceAllowlist is a list of glob patterns (see path.Match) matching the
+// namespaces served by this node, an empty list allows every namespace.
+type namespaceAllowlist []string
+
+func parseNamespaceAllowlist(s string) (namespaceAllowlist, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	patterns := strings.Split(s, ",")
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern `%s`: %w", pattern, err)
+		}
+	}
+
+	return namespaceAllowlist(patterns), nil
+}
+
+// Allowed returns true if ns matches at least one pattern of the list.
+func (l namespaceAllowlist) Allowed(ns string) bool {
+	if len(l) == 0 {
+		return true
+	}
+
+	for _, pattern := range l {
+		if ok, _ := path.Match(pattern, ns); ok {
+			return true
+		}
+	}
+
+	return false
+}
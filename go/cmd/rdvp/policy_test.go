@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceAllowlist(t *testing.T) {
+	allowlist, err := parseNamespaceAllowlist("berty/*,exact")
+	require.NoError(t, err)
+
+	tests := []struct {
+		ns      string
+		allowed bool
+	}{
+		{"berty/foo", true},
+		{"berty/", true},
+		{"exact", true},
+		{"exactly", false},
+		{"other/foo", false},
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.allowed, allowlist.Allowed(tc.ns), tc.ns)
+	}
+
+	var empty namespaceAllowlist
+	assert.True(t, empty.Allowed("anything"))
+
+	_, err = parseNamespaceAllowlist("[")
+	assert.Error(t, err)
+}
+
+func TestUsableAddr(t *testing.T) {
+	assert.True(t, usableAddr(ma.StringCast("/ip4/1.2.3.4/tcp/4040").Bytes()))
+	assert.True(t, usableAddr(ma.StringCast("/ip4/127.0.0.1/udp/4040/quic-v1").Bytes()))
+	assert.False(t, usableAddr(ma.StringCast("/ip4/0.0.0.0/tcp/4040").Bytes()))
+	assert.False(t, usableAddr(ma.StringCast("/ip6/::/tcp/4040").Bytes()))
+	assert.False(t, usableAddr(nil))
+	// unknown to this node, maybe not to the clients
+	assert.True(t, usableAddr([]byte("garbage")))
+
+	_, err := parseEmptyAddrPolicy("drop")
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// profilingCPUDuration is the longest CPU profile of a profiling
+	// round, the CPU is not profiled for the rest of the interval.
+	profilingCPUDuration = 10 * time.Second
+
+	profilingPushTimeout = 10 * time.Second
+)
+
+// pushProfiles profiles the process every interval and pushes a CPU and a
+// heap profile (pprof format) to endpoint, a Pyroscope compatible ingest
+// URL (ie. http://pyroscope:4040/ingest). The profiles are named
+// `rdvp.cpu` and `rdvp.heap`, with a deployment_id label if set.
+func pushProfiles(ctx context.Context, logger *zap.Logger, endpoint, deploymentID string, interval time.Duration) error {
+	client := &http.Client{Timeout: profilingPushTimeout}
+
+	labels := ""
+	if deploymentID != "" {
+		labels = "{deployment_id=" + deploymentID + "}"
+	}
+
+	cpuDuration := profilingCPUDuration
+	if interval < cpuDuration {
+		cpuDuration = interval
+	}
+
+	for {
+		start := time.Now()
+
+		var cpu bytes.Buffer
+		if err := pprof.StartCPUProfile(&cpu); err != nil {
+			// ie. another CPU profile is running
+			logger.Warn("unable to start the CPU profile", zap.Error(err))
+		} else {
+			select {
+			case <-time.After(cpuDuration):
+			case <-ctx.Done():
+			}
+			pprof.StopCPUProfile()
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err := pushProfile(ctx, client, endpoint, "rdvp.cpu"+labels, start, time.Now(), &cpu); err != nil {
+				logger.Warn("unable to push the CPU profile", zap.String("endpoint", stripUserinfo(endpoint)), zap.Error(err))
+			}
+		}
+
+		var heap bytes.Buffer
+		if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+			logger.Warn("unable to write the heap profile", zap.Error(err))
+		} else if err := pushProfile(ctx, client, endpoint, "rdvp.heap"+labels, start, time.Now(), &heap); err != nil {
+			logger.Warn("unable to push the heap profile", zap.String("endpoint", stripUserinfo(endpoint)), zap.Error(err))
+		}
+
+		select {
+		case <-time.After(time.Until(start.Add(interval))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pushProfile posts the pprof profile of the [from, until] period to
+// endpoint.
+func pushProfile(ctx context.Context, client *http.Client, endpoint, name string, from, until time.Time, profile io.Reader) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	q := u.Query()
+	q.Set("name", name)
+	q.Set("from", strconv.FormatInt(from.Unix(), 10))
+	q.Set("until", strconv.FormatInt(until.Unix(), 10))
+	q.Set("format", "pprof")
+	q.Set("spyName", "gospy")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), profile)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
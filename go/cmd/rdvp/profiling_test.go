@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPushProfiles(t *testing.T) {
+	var (
+		muNames sync.Mutex
+		names   []string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, body)
+		assert.Equal(t, "pprof", r.URL.Query().Get("format"))
+
+		muNames.Lock()
+		names = append(names, r.URL.Query().Get("name"))
+		muNames.Unlock()
+	}))
+	defer collector.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- pushProfiles(ctx, zap.NewNop(), collector.URL+"/ingest", "blue", 50*time.Millisecond)
+	}()
+
+	require.Eventually(t, func() bool {
+		muNames.Lock()
+		defer muNames.Unlock()
+		return len(names) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	muNames.Lock()
+	defer muNames.Unlock()
+	assert.Equal(t, []string{"rdvp.cpu{deployment_id=blue}", "rdvp.heap{deployment_id=blue}"}, names[:2])
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/quic-go/quic-go"
+)
+
+// isStatelessReset reports whether err comes from a QUIC connection closed
+// by a stateless reset of the remote: the client lost the connection state
+// (restarted, changed network), which is routine for mobile clients.
+func isStatelessReset(err error) bool {
+	var resetErr *quic.StatelessResetError
+	return errors.As(err, &resetErr)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStatelessReset(t *testing.T) {
+	assert.True(t, isStatelessReset(fmt.Errorf("read: %w", &quic.StatelessResetError{})))
+	assert.False(t, isStatelessReset(io.EOF))
+	assert.False(t, isStatelessReset(&quic.IdleTimeoutError{}))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// registrationIndexPruneInterval is the interval between two deletions
+	// of the expired registrations of the index
+	registrationIndexPruneInterval = time.Minute
+
+	// registrationIndexMaxSize bounds the number of registrations tracked by
+	// the index, the registrations beyond it are not tracked
+	registrationIndexMaxSize = 1 << 20
+)
+
+type registrationKey struct {
+	peer libp2p_peer.ID
+	ns   string
+}
+
+type registrationInfo struct {
+	registeredAt time.Time
+	expireAt     time.Time
+}
+
+// registrationIndex keeps track, in memory, of the active registrations.
+// The DB only stores the expiration of each registration, this index also
+// knows when they were made.
+type registrationIndex struct {
+	maxSize int
+
+	muRegs sync.Mutex
+	regs   map[registrationKey]registrationInfo
+}
+
+func newRegistrationIndex() *registrationIndex {
+	return &registrationIndex{
+		maxSize: registrationIndexMaxSize,
+		regs:    make(map[registrationKey]registrationInfo),
+	}
+}
+
+func (idx *registrationIndex) add(p libp2p_peer.ID, ns string, ttl int) {
+	now := time.Now()
+	idx.set(registrationKey{peer: p, ns: ns}, registrationInfo{
+		registeredAt: now,
+		expireAt:     now.Add(time.Duration(ttl) * time.Second),
+	}, now)
+}
+
+func (idx *registrationIndex) set(key registrationKey, info registrationInfo, now time.Time) {
+	idx.muRegs.Lock()
+	defer idx.muRegs.Unlock()
+
+	if _, ok := idx.regs[key]; !ok && len(idx.regs) >= idx.maxSize {
+		if idx.pruneLocked(now); len(idx.regs) >= idx.maxSize {
+			return
+		}
+	}
+	idx.regs[key] = info
+}
+
+// load adds the active registrations stored in db, made before the node
+// started. Their registration date is unknown, they are assumed made with
+// the maximum ttl (maxTTL, in seconds), which is the case of the
+// registrations using the default ttl.
+func (idx *registrationIndex) load(ctx context.Context, db *sql.DB, maxTTL int64) (int, error) {
+	now := time.Now()
+
+	rows, err := db.QueryContext(ctx, "SELECT peer, ns, expire FROM Registrations WHERE expire > ?", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	loaded := 0
+	for rows.Next() {
+		var (
+			peer, ns string
+			expire   int64
+		)
+		if err := rows.Scan(&peer, &ns, &expire); err != nil {
+			return loaded, err
+		}
+
+		p, err := libp2p_peer.Decode(peer)
+		if err != nil {
+			continue
+		}
+
+		expireAt := time.Unix(expire, 0)
+		registeredAt := expireAt.Add(-time.Duration(maxTTL) * time.Second)
+		if registeredAt.After(now) {
+			registeredAt = now
+		}
+
+		idx.set(registrationKey{peer: p, ns: ns}, registrationInfo{
+			registeredAt: registeredAt,
+			expireAt:     expireAt,
+		}, now)
+		loaded++
+	}
+
+	return loaded, rows.Err()
+}
+
+// remove drops the registration of p on ns, or all the registrations of p
+// if ns is empty.
+func (idx *registrationIndex) remove(p libp2p_peer.ID, ns string) {
+	idx.muRegs.Lock()
+	defer idx.muRegs.Unlock()
+
+	if ns != "" {
+		delete(idx.regs, registrationKey{peer: p, ns: ns})
+		return
+	}
+
+	for key := range idx.regs {
+		if key.peer == p {
+			delete(idx.regs, key)
+		}
+	}
+}
+
+func (idx *registrationIndex) pruneLocked(now time.Time) {
+	for key, info := range idx.regs {
+		if info.expireAt.Before(now) {
+			delete(idx.regs, key)
+		}
+	}
+}
+
+// run deletes the expired registrations every interval until ctx is done,
+// so the index doesn't grow when it is not read.
+func (idx *registrationIndex) run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			idx.muRegs.Lock()
+			idx.pruneLocked(now)
+			idx.muRegs.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// oldest returns the registration date of the oldest active registration,
+// expired registrations are pruned on the way.
+func (idx *registrationIndex) oldest() (oldest time.Time, ok bool) {
+	now := time.Now()
+
+	idx.muRegs.Lock()
+	defer idx.muRegs.Unlock()
+
+	idx.pruneLocked(now)
+	for _, info := range idx.regs {
+		if !ok || info.registeredAt.Before(oldest) {
+			oldest, ok = info.registeredAt, true
+		}
+	}
+
+	return oldest, ok
+}
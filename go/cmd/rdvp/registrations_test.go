@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationIndexPrune(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	idx := newRegistrationIndex()
+	idx.maxSize = 2
+
+	now := time.Now()
+	idx.set(registrationKey{peer: "p1", ns: "ns"}, registrationInfo{registeredAt: now, expireAt: now.Add(-time.Second)}, now)
+	idx.add("p2", "ns", 60)
+	idx.add("p3", "ns", 60)
+
+	// the expired registration made room for p3, the index is full
+	idx.add("p4", "ns", 60)
+	idx.muRegs.Lock()
+	assert.Len(t, idx.regs, 2)
+	assert.NotContains(t, idx.regs, registrationKey{peer: "p4", ns: "ns"})
+	idx.muRegs.Unlock()
+
+	idx.set(registrationKey{peer: "p2", ns: "ns"}, registrationInfo{registeredAt: now, expireAt: now.Add(-time.Second)}, now)
+	go idx.run(ctx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		idx.muRegs.Lock()
+		defer idx.muRegs.Unlock()
+		return len(idx.regs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRegistrationIndexLoad(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rdvp.db")
+	rdb, err := libp2p_rpdb.OpenDB(ctx, path)
+	require.NoError(t, err)
+	require.NoError(t, rdb.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	p, err := libp2p_peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	require.NoError(t, err)
+	now := time.Now()
+	_, err = db.ExecContext(ctx, "INSERT INTO Registrations (peer, ns, expire, addrs) VALUES (?, ?, ?, ?), (?, ?, ?, ?)",
+		p.Pretty(), "active", now.Add(time.Hour).Unix(), []byte{},
+		p.Pretty(), "expired", now.Add(-time.Hour).Unix(), []byte{})
+	require.NoError(t, err)
+
+	idx := newRegistrationIndex()
+	loaded, err := idx.load(ctx, db, int64((2 * time.Hour).Seconds()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+
+	// assumed registered with the 2h max ttl, one hour ago
+	oldest, ok := idx.oldest()
+	require.True(t, ok)
+	assert.WithinDuration(t, now.Add(-time.Hour), oldest, 2*time.Second)
+
+	count, err := idx.count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
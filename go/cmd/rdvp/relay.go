@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	libp2p_relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+// relayLimit returns the relayed connection limits, nil when neither is
+// set. The relay enforces both limits of a RelayLimit, an unset one gets
+// the largest value the clients can be told.
+func relayLimit(duration time.Duration, data int64) *libp2p_relayv2.RelayLimit {
+	if duration <= 0 && data <= 0 {
+		return nil
+	}
+
+	if duration <= 0 {
+		// advertised as a uint32 number of seconds
+		duration = math.MaxUint32 * time.Second
+	}
+
+	if data <= 0 {
+		data = math.MaxInt64
+	}
+
+	return &libp2p_relayv2.RelayLimit{Duration: duration, Data: data}
+}
+
+// newRelayResources returns the default relay resources with the given
+// reservation, circuit and buffer sizes and connection limits.
+func newRelayResources(maxReservations, maxCircuits, bufferSize int, limit *libp2p_relayv2.RelayLimit) (libp2p_relayv2.Resources, error) {
+	switch {
+	case maxReservations <= 0:
+		return libp2p_relayv2.Resources{}, fmt.Errorf("invalid relay max reservations %d, should be positive", maxReservations)
+	case maxCircuits <= 0:
+		return libp2p_relayv2.Resources{}, fmt.Errorf("invalid relay max circuits %d, should be positive", maxCircuits)
+	case bufferSize <= 0:
+		return libp2p_relayv2.Resources{}, fmt.Errorf("invalid relay buffer size %d, should be positive", bufferSize)
+	}
+
+	res := libp2p_relayv2.DefaultResources()
+	res.MaxReservations = maxReservations
+	res.MaxCircuits = maxCircuits
+	res.BufferSize = bufferSize
+	res.Limit = limit
+	return res, nil
+}
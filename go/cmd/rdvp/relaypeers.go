@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+type relayPeerBytes struct {
+	Peer  libp2p_peer.ID
+	Bytes int64
+}
+
+// relayPeerBandwidth accounts the relayed bytes by reserving peer, by
+// window. The bytes are the ones of the stop streams (the relay to
+// reserving peer leg of the circuits), so there is at most one entry per
+// reservation in a window.
+type relayPeerBandwidth struct {
+	muPeers sync.Mutex
+	current map[libp2p_peer.ID]int64
+	last    []relayPeerBytes
+}
+
+func newRelayPeerBandwidth() *relayPeerBandwidth {
+	return &relayPeerBandwidth{current: make(map[libp2p_peer.ID]int64)}
+}
+
+func (b *relayPeerBandwidth) add(p libp2p_peer.ID, size int64) {
+	b.muPeers.Lock()
+	b.current[p] += size
+	b.muPeers.Unlock()
+}
+
+// rotate ends the current window and keeps its n biggest consumers, by
+// decreasing bytes.
+func (b *relayPeerBandwidth) rotate(n int) {
+	b.muPeers.Lock()
+	current := b.current
+	b.current = make(map[libp2p_peer.ID]int64, len(current))
+	b.muPeers.Unlock()
+
+	top := make([]relayPeerBytes, 0, len(current))
+	for p, bytes := range current {
+		top = append(top, relayPeerBytes{Peer: p, Bytes: bytes})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Bytes != top[j].Bytes {
+			return top[i].Bytes > top[j].Bytes
+		}
+		return top[i].Peer < top[j].Peer
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	b.muPeers.Lock()
+	b.last = top
+	b.muPeers.Unlock()
+}
+
+// top returns the biggest consumers of the last complete window.
+func (b *relayPeerBandwidth) top() []relayPeerBytes {
+	b.muPeers.Lock()
+	defer b.muPeers.Unlock()
+	return b.last
+}
+
+// rotateRelayPeerBandwidth rotates the window of b every interval, keeping
+// its n biggest consumers.
+func rotateRelayPeerBandwidth(ctx context.Context, b *relayPeerBandwidth, n int, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.rotate(n)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
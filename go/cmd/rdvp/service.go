@@ -0,0 +1,794 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	// nolint:staticcheck
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_rppb "github.com/berty/go-libp2p-rendezvous/pb"
+	ggio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
+	libp2p_host "github.com/libp2p/go-libp2p/core/host"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.uber.org/zap"
+)
+
+// serviceOptions configures the policies enforced by the rendezvous service.
+type serviceOptions struct {
+	Logger        *zap.Logger
+	Metrics       *rdvpMetrics
+	Registrations *registrationIndex
+
+	// NamespaceAllowlist, if set, restricts the namespaces served.
+	NamespaceAllowlist namespaceFilter
+
+	// AugmentObservedAddr adds the public address we observed on the
+	// connection to the addresses of the registration.
+	AugmentObservedAddr bool
+
+	// IdleTracker, if set, is notified of the rendezvous activity of each
+	// connection.
+	IdleTracker *idleTracker
+
+	// MaxNamespaceLength caps the length of the registered and discovered
+	// namespaces, defaults to libp2p_rp.MaxNamespaceLength, which it can't
+	// exceed.
+	MaxNamespaceLength int
+
+	// NamespacePattern, if set, must match the registered and discovered
+	// namespaces.
+	NamespacePattern *regexp.Regexp
+
+	// MaintenanceMessage, if set, starts the service in maintenance, see
+	// service.setMaintenanceMessage.
+	MaintenanceMessage string
+
+	// MaxResponseBytes, if positive, caps the serialized size of the
+	// discovery responses, see truncateDiscover.
+	MaxResponseBytes int
+
+	// ShardMap, if set, refers the clients to the node owning the
+	// namespaces this node doesn't own.
+	ShardMap shardMap
+
+	// ProtocolIDs are the protocol IDs the service is served under,
+	// defaults to libp2p_rp.RendezvousProto. Serving an old and a new ID
+	// keeps both generations of clients working during a protocol bump.
+	ProtocolIDs []protocol.ID
+
+	// NewNamespaceLimiter, if set, throttles the peers registering in
+	// namespaces without active registration.
+	NewNamespaceLimiter *peerRateLimiter
+
+	// NamespaceExists tells NewNamespaceLimiter whether a namespace has
+	// active registrations, defaults to looking in Registrations.
+	NamespaceExists namespaceExistsFunc
+
+	// DiscoverLimiter, if set, throttles the discover queries of the
+	// peers.
+	DiscoverLimiter *peerRateLimiter
+
+	// StreamReadDeadline and StreamWriteDeadline, if set, bound each read
+	// of a request and each write of a response, the stream is reset
+	// when they are exceeded.
+	StreamReadDeadline  time.Duration
+	StreamWriteDeadline time.Duration
+
+	// EmptyAddrPolicy is the handling of the registrations without usable
+	// address, defaults to emptyAddrReject.
+	EmptyAddrPolicy emptyAddrPolicy
+
+	// AccessLogger, if set, logs an entry for each request.
+	AccessLogger *zap.Logger
+
+	// HealthCheckPeer, if set, is the peer ID of the deep health check
+	// client of the node, see isHealthCheck.
+	HealthCheckPeer libp2p_peer.ID
+}
+
+// parseProtocolIDs returns the protocol IDs the service is served under:
+// id and, if set, the legacy one kept during a protocol migration.
+func parseProtocolIDs(id, legacy string) ([]protocol.ID, error) {
+	ids := []protocol.ID{}
+	for _, raw := range []string{id, legacy} {
+		if raw == "" {
+			continue
+		}
+		if !strings.HasPrefix(raw, "/") {
+			return nil, fmt.Errorf("invalid protocol ID `%s`, should start with a /", raw)
+		}
+		ids = append(ids, protocol.ID(raw))
+	}
+
+	switch {
+	case id == "":
+		return nil, fmt.Errorf("empty protocol ID")
+	case id == legacy:
+		return nil, fmt.Errorf("the legacy protocol ID is the same as the protocol ID `%s`", id)
+	}
+
+	return ids, nil
+}
+
+// service is a rendezvous service speaking the same protocol as
+// libp2p_rp.RendezvousService, with policy enforcement and instrumentation
+// around each request.
+type service struct {
+	logger  *zap.Logger
+	metrics *rdvpMetrics
+	opts    serviceOptions
+
+	db  libp2p_rpdbi.DB
+	rzs []libp2p_rp.RendezvousSync
+
+	// maintenance is the message attached to the successful responses
+	// while the node is in maintenance, empty otherwise.
+	maintenance atomic.Pointer[string]
+
+	// registrationMaxTTL is the maximum ttl of the registrations, in
+	// seconds, adjustable at runtime, 0 means libp2p_rp.MaxTTL.
+	registrationMaxTTL atomic.Int64
+}
+
+func newService(host libp2p_host.Host, db libp2p_rpdbi.DB, opts serviceOptions, rzs ...libp2p_rp.RendezvousSync) *service {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = newRdvpMetrics()
+	}
+	if opts.Registrations == nil {
+		opts.Registrations = newRegistrationIndex()
+	}
+	if opts.NamespaceExists == nil {
+		opts.NamespaceExists = opts.Registrations.namespaceExists
+	}
+	if opts.MaxNamespaceLength <= 0 || opts.MaxNamespaceLength > libp2p_rp.MaxNamespaceLength {
+		opts.MaxNamespaceLength = libp2p_rp.MaxNamespaceLength
+	}
+	if len(opts.ProtocolIDs) == 0 {
+		opts.ProtocolIDs = []protocol.ID{libp2p_rp.RendezvousProto}
+	}
+
+	svc := &service{
+		logger:  opts.Logger,
+		metrics: opts.Metrics,
+		opts:    opts,
+		db:      db,
+		rzs:     rzs,
+	}
+	svc.setMaintenanceMessage(opts.MaintenanceMessage)
+	for _, id := range opts.ProtocolIDs {
+		host.SetStreamHandler(id, svc.handleStream)
+	}
+	return svc
+}
+
+// setMaintenanceMessage sets the message sent to the clients in the status
+// text of the successful register and discover responses, so cooperating
+// clients can migrate to another node. An empty message ends the
+// maintenance.
+func (svc *service) setMaintenanceMessage(msg string) {
+	svc.maintenance.Store(&msg)
+}
+
+func (svc *service) maintenanceMessage() string {
+	if msg := svc.maintenance.Load(); msg != nil {
+		return *msg
+	}
+	return ""
+}
+
+// isHealthCheck reports whether the request of p on ns is a self-test of the
+// node deep health check. The self-tests are exempted from the namespace
+// policies, so the policies can't make the node unhealthy, and are not
+// forwarded to the sync drivers.
+func (svc *service) isHealthCheck(p libp2p_peer.ID, ns string) bool {
+	return svc.opts.HealthCheckPeer != "" && p == svc.opts.HealthCheckPeer && ns == healthCheckNamespace
+}
+
+func (svc *service) namespaceAllowed(ns string) bool {
+	return svc.opts.NamespaceAllowlist == nil || svc.opts.NamespaceAllowlist.Allowed(ns)
+}
+
+// setMaxTTL sets the maximum ttl of the new registrations, in seconds,
+// between 1 and libp2p_rp.MaxTTL, it returns the previous value.
+func (svc *service) setMaxTTL(ttl int64) (int64, error) {
+	if ttl <= 0 || ttl > libp2p_rp.MaxTTL {
+		return 0, fmt.Errorf("max ttl must be between 1 and %d", libp2p_rp.MaxTTL)
+	}
+
+	prev := svc.registrationMaxTTL.Swap(ttl)
+	if prev == 0 {
+		prev = libp2p_rp.MaxTTL
+	}
+	return prev, nil
+}
+
+func (svc *service) maxTTL() int64 {
+	if ttl := svc.registrationMaxTTL.Load(); ttl > 0 {
+		return ttl
+	}
+	return libp2p_rp.MaxTTL
+}
+
+func (svc *service) handleStream(s libp2p_network.Stream) {
+	defer s.Reset()
+
+	pid := s.Conn().RemotePeer()
+	svc.logger.Debug("new stream", zap.Stringer("peer", pid))
+
+	r := ggio.NewDelimitedReader(s, libp2p_network.MessageSizeMax)
+	w := ggio.NewDelimitedWriter(s)
+
+	for {
+		var req libp2p_rppb.Message
+		var res libp2p_rppb.Message
+
+		if d := svc.opts.StreamReadDeadline; d > 0 {
+			_ = s.SetReadDeadline(time.Now().Add(d))
+		}
+
+		if err := r.ReadMsg(&req); err != nil {
+			if !svc.streamError(pid, err) {
+				svc.deadlineExceeded(pid, "read", err)
+			}
+			return
+		}
+
+		svc.opts.IdleTracker.touch(s.Conn())
+		svc.metrics.protocolRequests.WithLabelValues(string(s.Protocol())).Inc()
+
+		switch t := req.GetType(); t {
+		case libp2p_rppb.Message_REGISTER:
+			res.Type = libp2p_rppb.Message_REGISTER_RESPONSE
+			res.RegisterResponse = svc.handleRegister(s.Conn(), req.GetRegister())
+
+			// the granted ttl if accepted, the requested one otherwise
+			ttl := req.GetRegister().GetTtl()
+			if res.RegisterResponse.GetStatus() == libp2p_rppb.Message_OK {
+				ttl = res.RegisterResponse.GetTtl()
+			}
+			svc.logAccess("register", pid, req.GetRegister().GetNs(), res.RegisterResponse.GetStatus(), res.RegisterResponse.GetStatusText(),
+				zap.Int64("ttl", ttl))
+
+		case libp2p_rppb.Message_UNREGISTER:
+			status := libp2p_rppb.Message_OK
+			if err := svc.handleUnregister(pid, req.GetUnregister()); err != nil {
+				svc.logger.Debug("unable to unregister peer", zap.Stringer("peer", pid), zap.Error(err))
+				status = libp2p_rppb.Message_E_INTERNAL_ERROR
+			}
+			svc.logAccess("unregister", pid, req.GetUnregister().GetNs(), status, "")
+			continue
+
+		case libp2p_rppb.Message_DISCOVER:
+			res.Type = libp2p_rppb.Message_DISCOVER_RESPONSE
+			res.DiscoverResponse = svc.handleDiscover(pid, req.GetDiscover())
+			svc.logAccess("discover", pid, req.GetDiscover().GetNs(), res.DiscoverResponse.GetStatus(), res.DiscoverResponse.GetStatusText(),
+				zap.Int("results", len(res.DiscoverResponse.GetRegistrations())))
+
+		case libp2p_rppb.Message_DISCOVER_SUBSCRIBE:
+			res.Type = libp2p_rppb.Message_DISCOVER_SUBSCRIBE_RESPONSE
+			res.DiscoverSubscribeResponse = svc.handleDiscoverSubscribe(pid, req.GetDiscoverSubscribe())
+			svc.logAccess("subscribe", pid, req.GetDiscoverSubscribe().GetNs(), res.DiscoverSubscribeResponse.GetStatus(), res.DiscoverSubscribeResponse.GetStatusText())
+
+		default:
+			svc.logger.Debug("unexpected message", zap.String("type", t.String()))
+			return
+		}
+
+		if d := svc.opts.StreamWriteDeadline; d > 0 {
+			_ = s.SetWriteDeadline(time.Now().Add(d))
+		}
+
+		if err := w.WriteMsg(&res); err != nil {
+			if !svc.streamError(pid, err) && !svc.deadlineExceeded(pid, "write", err) {
+				svc.logger.Debug("unable to write response", zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+// logAccess logs the outcome of a request of p on ns to the access logger,
+// if any. The status text of the rejected requests is logged as the reason.
+func (svc *service) logAccess(op string, p libp2p_peer.ID, ns string, status libp2p_rppb.Message_ResponseStatus, text string, fields ...zap.Field) {
+	if svc.opts.AccessLogger == nil {
+		return
+	}
+
+	fields = append(fields, zap.Stringer("peer", p), zap.String("ns", ns), zap.String("status", status.String()))
+	if status == libp2p_rppb.Message_OK {
+		fields = append(fields, zap.String("outcome", "accepted"))
+	} else {
+		fields = append(fields, zap.String("outcome", "rejected"), zap.String("reason", text))
+	}
+
+	svc.opts.AccessLogger.Info(op, fields...)
+}
+
+// streamError accounts for the stream errors caused by a QUIC stateless
+// reset, which are not real errors, it reports whether err was one.
+func (svc *service) streamError(p libp2p_peer.ID, err error) bool {
+	if !isStatelessReset(err) {
+		return false
+	}
+
+	svc.metrics.quicStatelessResets.Inc()
+	svc.logger.Debug("stream interrupted by a QUIC stateless reset", zap.Stringer("peer", p))
+	return true
+}
+
+// handlerError logs and accounts an internal error of the op handler. The
+// errors caused by a cancelled context (ie. an operation interrupted by the
+// shutdown) are not real errors, they are only logged at debug level.
+func (svc *service) handlerError(op, msg string, err error) {
+	if errors.Is(err, context.Canceled) {
+		svc.logger.Debug(msg, zap.String("op", op), zap.Error(err))
+		return
+	}
+
+	svc.metrics.handlerErrors.WithLabelValues(op).Inc()
+	svc.logger.Error(msg, zap.String("op", op), zap.Error(err))
+}
+
+// deadlineExceeded accounts for the streams reset because the read or
+// write (phase) of a message exceeded its deadline, it reports whether err
+// was a deadline error.
+func (svc *service) deadlineExceeded(p libp2p_peer.ID, phase string, err error) bool {
+	var nerr net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) && !(errors.As(err, &nerr) && nerr.Timeout()) {
+		return false
+	}
+
+	svc.metrics.deadlineResets.WithLabelValues(phase).Inc()
+	svc.logger.Debug("stream deadline exceeded", zap.Stringer("peer", p), zap.String("phase", phase))
+	return true
+}
+
+func (svc *service) handleRegister(c libp2p_network.Conn, m *libp2p_rppb.Message_Register) *libp2p_rppb.Message_RegisterResponse {
+	p := c.RemotePeer()
+	ns := m.GetNs()
+	if ns == "" {
+		return svc.rejectRegister(policyNamespace, libp2p_rppb.Message_E_INVALID_NAMESPACE, "unspecified namespace")
+	}
+
+	healthCheck := svc.isHealthCheck(p, ns)
+
+	if len(ns) > svc.opts.MaxNamespaceLength && !healthCheck {
+		return svc.rejectRegister(policyNamespace, libp2p_rppb.Message_E_INVALID_NAMESPACE, "namespace too long")
+	}
+
+	if svc.opts.NamespacePattern != nil && !healthCheck && !svc.opts.NamespacePattern.MatchString(ns) {
+		return svc.rejectRegister(policyNamespacePattern, libp2p_rppb.Message_E_INVALID_NAMESPACE, "invalid namespace")
+	}
+
+	if !healthCheck && !svc.namespaceAllowed(ns) {
+		svc.metrics.namespaceNotAllowed.WithLabelValues("register").Inc()
+		return svc.rejectRegister(policyNamespaceAllowlist, libp2p_rppb.Message_E_NOT_AUTHORIZED, "namespace not allowed")
+	}
+
+	if owner := svc.opts.ShardMap.referral(ns); !healthCheck && owner != nil {
+		svc.metrics.shardReferrals.WithLabelValues("register").Inc()
+		return svc.rejectRegister(policyShard, libp2p_rppb.Message_E_UNAVAILABLE, shardReferralPrefix+owner.String())
+	}
+
+	mpi := m.GetPeer()
+	if mpi == nil {
+		return svc.rejectRegister(policyPeerInfo, libp2p_rppb.Message_E_INVALID_PEER_INFO, "missing peer info")
+	}
+
+	if mpid := mpi.GetId(); mpid != nil {
+		mp, err := libp2p_peer.IDFromBytes(mpid)
+		if err != nil {
+			return svc.rejectRegister(policyPeerInfo, libp2p_rppb.Message_E_INVALID_PEER_INFO, "bad peer id")
+		}
+
+		if mp != p {
+			return svc.rejectRegister(policyPeerInfo, libp2p_rppb.Message_E_INVALID_PEER_INFO, "peer id mismatch")
+		}
+	}
+
+	maddrs := mpi.GetAddrs()
+	usable := false
+	for _, maddr := range maddrs {
+		usable = usable || usableAddr(maddr)
+	}
+	if !usable {
+		switch svc.opts.EmptyAddrPolicy {
+		case emptyAddrAccept:
+		case emptyAddrAugment:
+			observed, ok := observedAddr(c, nil)
+			if !ok {
+				return svc.rejectRegister(policyEmptyAddrs, libp2p_rppb.Message_E_INVALID_PEER_INFO, "no usable peer address")
+			}
+			maddrs = [][]byte{observed.Bytes()}
+			svc.metrics.registrationsAugmented.Inc()
+		default:
+			return svc.rejectRegister(policyEmptyAddrs, libp2p_rppb.Message_E_INVALID_PEER_INFO, "no usable peer address")
+		}
+	}
+
+	if svc.opts.AugmentObservedAddr {
+		if observed, ok := observedAddr(c, maddrs); ok {
+			maddrs = append(maddrs, observed.Bytes())
+			svc.metrics.registrationsAugmented.Inc()
+		}
+	}
+
+	// checked once augmented, the stored addrs must fit the limit
+	mlen := 0
+	for _, maddr := range maddrs {
+		mlen += len(maddr)
+	}
+	if mlen > libp2p_rp.MaxPeerAddressLength {
+		return svc.rejectRegister(policyPeerInfo, libp2p_rppb.Message_E_INVALID_PEER_INFO, "peer info too long")
+	}
+
+	maxTTL := svc.maxTTL()
+	mttl := m.GetTtl()
+	if mttl < 0 || mttl > maxTTL {
+		return svc.rejectRegister(policyTTL, libp2p_rppb.Message_E_INVALID_TTL, "bad ttl")
+	}
+
+	ttl := libp2p_rp.DefaultTTL
+	if mttl > 0 {
+		ttl = int(mttl)
+	} else if int64(ttl) > maxTTL {
+		ttl = int(maxTTL)
+	}
+
+	// simple limit to defend against trivial DoS attacks (eg a peer
+	// connects and keeps registering until it fills our db)
+	rcount, err := svc.db.CountRegistrations(p)
+	if err != nil {
+		svc.handlerError("register", "unable to count registrations", err)
+		return newRegisterResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "database error")
+	}
+
+	if rcount > libp2p_rp.MaxRegistrations {
+		svc.logger.Warn("too many registrations", zap.Stringer("peer", p))
+		return svc.rejectRegister(policyQuota, libp2p_rppb.Message_E_NOT_AUTHORIZED, "too many registrations")
+	}
+
+	if limiter := svc.opts.NewNamespaceLimiter; limiter != nil && !healthCheck {
+		exists, err := svc.opts.NamespaceExists(context.Background(), ns)
+		if err != nil {
+			svc.handlerError("register", "unable to look up the namespace", err)
+			return newRegisterResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "database error")
+		}
+
+		if !exists && !limiter.allow(p, time.Now()) {
+			svc.logger.Debug("too many new namespaces", zap.Stringer("peer", p))
+			return svc.rejectRegister(policyNewNamespaceRate, libp2p_rppb.Message_E_NOT_AUTHORIZED, "too many new namespaces")
+		}
+	}
+
+	counter, err := svc.db.Register(p, ns, maddrs, ttl)
+	if errors.Is(err, errWriteQueueFull) {
+		return svc.rejectRegister(policyWriteQueue, libp2p_rppb.Message_E_UNAVAILABLE, "too many concurrent registrations, retry later")
+	}
+	if err != nil {
+		svc.handlerError("register", "unable to register", err)
+		return newRegisterResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "database error")
+	}
+
+	svc.logger.Debug("registered peer", zap.Stringer("peer", p), zap.String("ns", ns), zap.Int("ttl", ttl))
+	if !healthCheck {
+		svc.metrics.registrationsAccepted.Inc()
+		svc.opts.Registrations.add(p, ns, ttl)
+		for _, maddr := range maddrs {
+			svc.metrics.registrationAddrTypes.WithLabelValues(addrType(maddr)).Inc()
+		}
+
+		for _, rzs := range svc.rzs {
+			rzs.Register(p, ns, maddrs, ttl, counter)
+		}
+	}
+
+	return &libp2p_rppb.Message_RegisterResponse{
+		Status:     libp2p_rppb.Message_OK,
+		StatusText: svc.maintenanceMessage(),
+		Ttl:        int64(ttl),
+	}
+}
+
+// rejectRegister accounts a registration rejected by policy.
+func (svc *service) rejectRegister(policy registrationPolicy, status libp2p_rppb.Message_ResponseStatus, text string) *libp2p_rppb.Message_RegisterResponse {
+	svc.metrics.registrationRejected.WithLabelValues(string(policy)).Inc()
+	return newRegisterResponseError(status, text)
+}
+
+func (svc *service) handleUnregister(p libp2p_peer.ID, m *libp2p_rppb.Message_Unregister) error {
+	ns := m.GetNs()
+
+	if mpid := m.GetId(); mpid != nil {
+		mp, err := libp2p_peer.IDFromBytes(mpid)
+		if err != nil {
+			return err
+		}
+
+		if mp != p {
+			return fmt.Errorf("peer id mismatch: %s asked to unregister %s", p, mp)
+		}
+	}
+
+	if err := svc.db.Unregister(p, ns); err != nil {
+		return err
+	}
+
+	svc.opts.Registrations.remove(p, ns)
+	svc.logger.Debug("unregistered peer", zap.Stringer("peer", p), zap.String("ns", ns))
+
+	if svc.isHealthCheck(p, ns) {
+		return nil
+	}
+
+	for _, rzs := range svc.rzs {
+		rzs.Unregister(p, ns)
+	}
+
+	return nil
+}
+
+// evict removes all the registrations of p, it returns how many were removed.
+func (svc *service) evict(p libp2p_peer.ID) (int, error) {
+	count, err := svc.db.CountRegistrations(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := svc.db.Unregister(p, ""); err != nil {
+		return 0, err
+	}
+
+	svc.opts.Registrations.remove(p, "")
+	for _, rzs := range svc.rzs {
+		rzs.Unregister(p, "")
+	}
+
+	return count, nil
+}
+
+func (svc *service) handleDiscover(p libp2p_peer.ID, m *libp2p_rppb.Message_Discover) *libp2p_rppb.Message_DiscoverResponse {
+	ns := m.GetNs()
+	if status, text := svc.checkDiscover("discover", p, ns); status != libp2p_rppb.Message_OK {
+		return newDiscoverResponseError(status, text)
+	}
+
+	limit := libp2p_rp.MaxDiscoverLimit
+	if mlimit := m.GetLimit(); mlimit > 0 && mlimit < int64(limit) {
+		limit = int(mlimit)
+	}
+
+	cookie := m.GetCookie()
+	if cookie != nil && !svc.db.ValidCookie(ns, cookie) {
+		return newDiscoverResponseError(libp2p_rppb.Message_E_INVALID_COOKIE, "bad cookie")
+	}
+
+	regs, rcookie, err := svc.db.Discover(ns, cookie, limit)
+	if err != nil {
+		svc.handlerError("discover", "unable to query registrations", err)
+		return newDiscoverResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "database error")
+	}
+
+	res := newDiscoverResponse(regs, rcookie)
+	res.StatusText = svc.maintenanceMessage()
+	if svc.opts.MaxResponseBytes > 0 && res.Size() > svc.opts.MaxResponseBytes {
+		if res, err = svc.truncateDiscover(ns, cookie, res); err != nil {
+			svc.handlerError("discover", "unable to query registrations", err)
+			return newDiscoverResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "database error")
+		}
+	}
+
+	svc.metrics.discoverQueries.Inc()
+	svc.logger.Debug("discover query", zap.Stringer("peer", p), zap.String("ns", ns), zap.Int("results", len(res.Registrations)))
+
+	return res
+}
+
+// checkDiscover applies the namespace policies and the rate limit shared by
+// the discover queries and subscriptions of p on ns, op labels the metrics.
+// It returns Message_OK when the query is allowed.
+func (svc *service) checkDiscover(op string, p libp2p_peer.ID, ns string) (libp2p_rppb.Message_ResponseStatus, string) {
+	healthCheck := svc.isHealthCheck(p, ns)
+
+	if len(ns) > svc.opts.MaxNamespaceLength && !healthCheck {
+		return libp2p_rppb.Message_E_INVALID_NAMESPACE, "namespace too long"
+	}
+
+	// the "all namespaces" discoveries (empty ns) are served locally
+	if ns != "" && svc.opts.NamespacePattern != nil && !healthCheck && !svc.opts.NamespacePattern.MatchString(ns) {
+		return libp2p_rppb.Message_E_INVALID_NAMESPACE, "invalid namespace"
+	}
+
+	if limiter := svc.opts.DiscoverLimiter; limiter != nil && !limiter.allow(p, time.Now()) {
+		svc.metrics.discoverRejected.WithLabelValues("rate").Inc()
+		svc.logger.Debug("too many discover queries", zap.Stringer("peer", p), zap.String("op", op))
+		return libp2p_rppb.Message_E_UNAVAILABLE, "too many discover queries, retry later"
+	}
+
+	if !healthCheck && !svc.namespaceAllowed(ns) {
+		svc.metrics.namespaceNotAllowed.WithLabelValues(op).Inc()
+		return libp2p_rppb.Message_E_NOT_AUTHORIZED, "namespace not allowed"
+	}
+
+	if owner := svc.opts.ShardMap.referral(ns); ns != "" && !healthCheck && owner != nil {
+		svc.metrics.shardReferrals.WithLabelValues(op).Inc()
+		return libp2p_rppb.Message_E_UNAVAILABLE, shardReferralPrefix + owner.String()
+	}
+
+	return libp2p_rppb.Message_OK, ""
+}
+
+// truncateDiscover shrinks res to the registrations fitting in
+// MaxResponseBytes, at least one is kept so the client always makes
+// progress. The cookie is bound to the last registration returned by the
+// DB, so the query is run again with the reduced limit to get a cookie
+// continuing right after the truncated list.
+func (svc *service) truncateDiscover(ns string, cookie []byte, res *libp2p_rppb.Message_DiscoverResponse) (*libp2p_rppb.Message_DiscoverResponse, error) {
+	size := (&libp2p_rppb.Message_DiscoverResponse{Status: res.Status, StatusText: res.StatusText, Cookie: res.Cookie}).Size()
+
+	fit := 0
+	for _, reg := range res.Registrations {
+		// field tag + length prefix + message
+		l := reg.Size()
+		size += 1 + proto.SizeVarint(uint64(l)) + l
+		if size > svc.opts.MaxResponseBytes && fit > 0 {
+			break
+		}
+		fit++
+	}
+
+	regs, rcookie, err := svc.db.Discover(ns, cookie, fit)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.metrics.discoverTruncated.Inc()
+	truncated := newDiscoverResponse(regs, rcookie)
+	truncated.StatusText = res.StatusText
+	return truncated, nil
+}
+
+func (svc *service) handleDiscoverSubscribe(p libp2p_peer.ID, m *libp2p_rppb.Message_DiscoverSubscribe) *libp2p_rppb.Message_DiscoverSubscribeResponse {
+	ns := m.GetNs()
+	if status, text := svc.checkDiscover("subscribe", p, ns); status != libp2p_rppb.Message_OK {
+		return newDiscoverSubscribeResponseError(status, text)
+	}
+
+	for _, s := range svc.rzs {
+		rzSub, ok := s.(libp2p_rp.RendezvousSyncSubscribable)
+		if !ok {
+			continue
+		}
+
+		for _, supportedSubType := range m.GetSupportedSubscriptionTypes() {
+			if rzSub.GetServiceType() != supportedSubType {
+				continue
+			}
+
+			sub, err := rzSub.Subscribe(ns)
+			if err != nil {
+				svc.handlerError("subscribe", "unable to subscribe", err)
+				return newDiscoverSubscribeResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "error while subscribing")
+			}
+
+			return &libp2p_rppb.Message_DiscoverSubscribeResponse{
+				Status:              libp2p_rppb.Message_OK,
+				SubscriptionType:    supportedSubType,
+				SubscriptionDetails: sub,
+			}
+		}
+	}
+
+	return newDiscoverSubscribeResponseError(libp2p_rppb.Message_E_INTERNAL_ERROR, "subscription type not found")
+}
+
+// helpers
+
+// observedAddr returns the remote address of c if it is a public, direct
+// address which is not already part of addrs.
+func observedAddr(c libp2p_network.Conn, addrs [][]byte) (ma.Multiaddr, bool) {
+	remote := c.RemoteMultiaddr()
+
+	// relayed connections expose the relay address, not the peer one
+	if _, err := remote.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return nil, false
+	}
+
+	if !manet.IsPublicAddr(remote) {
+		return nil, false
+	}
+
+	for _, addr := range addrs {
+		if bytes.Equal(addr, remote.Bytes()) {
+			return nil, false
+		}
+	}
+
+	return remote, true
+}
+
+// addrType classifies a registered address by transport and scope: relay,
+// dns, ip4_public, ip4_private, ip6_public, ip6_private, loopback, other, or
+// invalid if it can't be decoded.
+func addrType(b []byte) string {
+	m, err := ma.NewMultiaddrBytes(b)
+	if err != nil {
+		return "invalid"
+	}
+
+	if _, err := m.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return "relay"
+	}
+
+	first, _ := ma.SplitFirst(m)
+	switch first.Protocol().Code {
+	case ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+		return "dns"
+	case ma.P_IP4, ma.P_IP6:
+	default:
+		return "other"
+	}
+
+	if manet.IsIPLoopback(m) {
+		return "loopback"
+	}
+
+	family := "ip4"
+	if first.Protocol().Code == ma.P_IP6 {
+		family = "ip6"
+	}
+
+	if manet.IsPublicAddr(m) {
+		return family + "_public"
+	}
+	return family + "_private"
+}
+
+func newRegisterResponseError(status libp2p_rppb.Message_ResponseStatus, text string) *libp2p_rppb.Message_RegisterResponse {
+	return &libp2p_rppb.Message_RegisterResponse{Status: status, StatusText: text}
+}
+
+func newDiscoverResponse(regs []libp2p_rpdbi.RegistrationRecord, cookie []byte) *libp2p_rppb.Message_DiscoverResponse {
+	rregs := make([]*libp2p_rppb.Message_Register, len(regs))
+	for i, reg := range regs {
+		rregs[i] = &libp2p_rppb.Message_Register{
+			Ns: reg.Ns,
+			Peer: &libp2p_rppb.Message_PeerInfo{
+				Id:    []byte(reg.Id),
+				Addrs: reg.Addrs,
+			},
+			Ttl: int64(reg.Ttl),
+		}
+	}
+
+	return &libp2p_rppb.Message_DiscoverResponse{
+		Status:        libp2p_rppb.Message_OK,
+		Registrations: rregs,
+		Cookie:        cookie,
+	}
+}
+
+func newDiscoverResponseError(status libp2p_rppb.Message_ResponseStatus, text string) *libp2p_rppb.Message_DiscoverResponse {
+	return &libp2p_rppb.Message_DiscoverResponse{Status: status, StatusText: text}
+}
+
+func newDiscoverSubscribeResponseError(status libp2p_rppb.Message_ResponseStatus, text string) *libp2p_rppb.Message_DiscoverSubscribeResponse {
+	return &libp2p_rppb.Message_DiscoverSubscribeResponse{Status: status, StatusText: text}
+}
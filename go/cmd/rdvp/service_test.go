@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	libp2p_rppb "github.com/berty/go-libp2p-rendezvous/pb"
+	"github.com/libp2p/go-libp2p"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDiscoverMaxResponseBytes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	const npeers = 10
+	for i := 0; i < npeers; i++ {
+		var addrs [][]byte
+		for j := 0; j < 10; j++ {
+			addrs = append(addrs, ma.StringCast(fmt.Sprintf("/ip4/10.0.%d.%d/tcp/4040", i, j)).Bytes())
+		}
+		priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+		p, err := libp2p_peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+
+		_, err = db.Register(p, "ns", addrs, 60)
+		require.NoError(t, err)
+	}
+
+	svc := &service{
+		logger:  zap.NewNop(),
+		metrics: newRdvpMetrics(),
+		opts:    serviceOptions{MaxResponseBytes: 1000, MaxNamespaceLength: libp2p_rp.MaxNamespaceLength},
+		db:      db,
+	}
+
+	var (
+		seen   = make(map[string]bool)
+		cookie []byte
+	)
+	for page := 0; len(seen) < npeers; page++ {
+		require.Less(t, page, npeers, "discovery doesn't make progress")
+
+		res := svc.handleDiscover("", &libp2p_rppb.Message_Discover{Ns: "ns", Cookie: cookie})
+		require.Equal(t, libp2p_rppb.Message_OK, res.Status)
+		require.NotEmpty(t, res.Registrations)
+		assert.LessOrEqual(t, res.Size(), 1000)
+
+		for _, reg := range res.Registrations {
+			assert.False(t, seen[string(reg.Peer.Id)], "registration returned twice")
+			seen[string(reg.Peer.Id)] = true
+		}
+		cookie = res.Cookie
+	}
+}
+
+func TestAddrType(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4040":          "ip4_public",
+		"/ip4/192.168.1.2/udp/4141/quic": "ip4_private",
+		"/ip4/127.0.0.1/tcp/4040":        "loopback",
+		"/ip6/2001:4860::1/tcp/4040":     "ip6_public",
+		"/ip6/fe80::1/tcp/4040":          "ip6_private",
+		"/dns4/example.com/tcp/4040":     "dns",
+		"/ip4/1.2.3.4/tcp/4040/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit": "relay",
+		"/unix/tmp/rdvp.sock": "other",
+	} {
+		assert.Equal(t, expected, addrType(ma.StringCast(addr).Bytes()), addr)
+	}
+
+	assert.Equal(t, "invalid", addrType([]byte{0xff}))
+}
+
+// remoteConn is a connection from a fixed remote address.
+type remoteConn struct {
+	libp2p_network.Conn
+	remote ma.Multiaddr
+}
+
+func (c remoteConn) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+func (c remoteConn) RemotePeer() libp2p_peer.ID    { return "" }
+
+func TestObservedAddr(t *testing.T) {
+	public := ma.StringCast("/ip4/1.2.3.4/tcp/4040")
+
+	for addr, expected := range map[string]bool{
+		"/ip4/1.2.3.4/tcp/4040":          true,
+		"/ip6/2001:4860::1/tcp/4040":     true,
+		"/ip4/192.168.1.2/udp/4141/quic": false,
+		"/ip4/127.0.0.1/tcp/4040":        false,
+		"/ip4/1.2.3.4/tcp/4040/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit": false,
+	} {
+		observed, ok := observedAddr(remoteConn{remote: ma.StringCast(addr)}, nil)
+		if assert.Equal(t, expected, ok, addr) && ok {
+			assert.Equal(t, addr, observed.String())
+		}
+	}
+
+	// already registered
+	_, ok := observedAddr(remoteConn{remote: public}, [][]byte{public.Bytes()})
+	assert.False(t, ok)
+}
+
+func TestAugmentedAddrsLength(t *testing.T) {
+	metrics := newRdvpMetrics()
+	svc := &service{
+		logger:  zap.NewNop(),
+		metrics: metrics,
+		opts:    serviceOptions{AugmentObservedAddr: true, MaxNamespaceLength: libp2p_rp.MaxNamespaceLength},
+	}
+
+	// exactly MaxPeerAddressLength before the observed addr is added
+	addr := ma.StringCast("/ip4/5.6.7.8/tcp/4040").Bytes()
+	addrs := make([][]byte, libp2p_rp.MaxPeerAddressLength/len(addr))
+	for i := range addrs {
+		addrs[i] = addr
+	}
+
+	res := svc.handleRegister(remoteConn{remote: ma.StringCast("/ip4/1.2.3.4/tcp/4040")}, &libp2p_rppb.Message_Register{
+		Ns:   "ns",
+		Peer: &libp2p_rppb.Message_PeerInfo{Addrs: addrs},
+	})
+	assert.Equal(t, libp2p_rppb.Message_E_INVALID_PEER_INFO, res.Status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyPeerInfo))))
+}
+
+func TestSetMaxTTL(t *testing.T) {
+	svc := &service{}
+	assert.Equal(t, int64(libp2p_rp.MaxTTL), svc.maxTTL())
+
+	prev, err := svc.setMaxTTL(3600)
+	require.NoError(t, err)
+	assert.Equal(t, int64(libp2p_rp.MaxTTL), prev)
+	assert.Equal(t, int64(3600), svc.maxTTL())
+
+	_, err = svc.setMaxTTL(0)
+	assert.Error(t, err)
+	_, err = svc.setMaxTTL(libp2p_rp.MaxTTL + 1)
+	assert.Error(t, err)
+	assert.Equal(t, int64(3600), svc.maxTTL())
+}
+
+func TestParseProtocolIDs(t *testing.T) {
+	ids, err := parseProtocolIDs(string(libp2p_rp.RendezvousProto), "")
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.ID{libp2p_rp.RendezvousProto}, ids)
+
+	ids, err = parseProtocolIDs("/rendezvous/2.0.0", "/rendezvous/1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.ID{"/rendezvous/2.0.0", "/rendezvous/1.0.0"}, ids)
+
+	for _, invalid := range [][2]string{
+		{"", "/rendezvous/1.0.0"},
+		{"rendezvous/2.0.0", ""},
+		{"/rendezvous/2.0.0", "rendezvous/1.0.0"},
+		{"/rendezvous/1.0.0", "/rendezvous/1.0.0"},
+	} {
+		_, err := parseProtocolIDs(invalid[0], invalid[1])
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestStreamReadDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// mocknet streams don't support deadlines
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer client.Close()
+
+	metrics := newRdvpMetrics()
+	_ = newService(server, db, serviceOptions{Metrics: metrics, StreamReadDeadline: 50 * time.Millisecond})
+
+	require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+	s, err := client.NewStream(ctx, server.ID(), libp2p_rp.RendezvousProto)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// send half a message, then stall
+	_, err = s.Write([]byte{10})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.deadlineResets.WithLabelValues("read")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// failingDB fails all the discoveries with err.
+type failingDB struct {
+	libp2p_rpdbi.DB
+	err error
+}
+
+func (db *failingDB) Discover(string, []byte, int) ([]libp2p_rpdbi.RegistrationRecord, []byte, error) {
+	return nil, nil, db.err
+}
+
+func TestHandlerErrorCancelled(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	db := &failingDB{}
+	svc := &service{
+		logger:  zap.New(core),
+		metrics: newRdvpMetrics(),
+		opts:    serviceOptions{MaxNamespaceLength: libp2p_rp.MaxNamespaceLength},
+		db:      db,
+	}
+
+	// interrupted by the shutdown: not an error
+	db.err = fmt.Errorf("query: %w", context.Canceled)
+	res := svc.handleDiscover("", &libp2p_rppb.Message_Discover{Ns: "ns"})
+	assert.Equal(t, libp2p_rppb.Message_E_INTERNAL_ERROR, res.Status)
+	assert.Zero(t, testutil.ToFloat64(svc.metrics.handlerErrors.WithLabelValues("discover")))
+	assert.Equal(t, 1, logs.FilterLevelExact(zapcore.DebugLevel).Len())
+
+	db.err = errors.New("disk I/O error")
+	res = svc.handleDiscover("", &libp2p_rppb.Message_Discover{Ns: "ns"})
+	assert.Equal(t, libp2p_rppb.Message_E_INTERNAL_ERROR, res.Status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.handlerErrors.WithLabelValues("discover")))
+	assert.Equal(t, 1, logs.FilterLevelExact(zapcore.ErrorLevel).Len())
+}
+
+func TestDiscoverRate(t *testing.T) {
+	svc := &service{
+		logger:  zap.NewNop(),
+		metrics: newRdvpMetrics(),
+		db:      &failingDB{},
+		opts:    serviceOptions{DiscoverLimiter: newPeerRateLimiter(2, peerRateLimiterPeers), MaxNamespaceLength: libp2p_rp.MaxNamespaceLength},
+	}
+
+	for i := 0; i < 2; i++ {
+		res := svc.handleDiscover("p1", &libp2p_rppb.Message_Discover{Ns: "ns"})
+		assert.Equal(t, libp2p_rppb.Message_OK, res.Status)
+	}
+
+	res := svc.handleDiscover("p1", &libp2p_rppb.Message_Discover{Ns: "ns"})
+	assert.Equal(t, libp2p_rppb.Message_E_UNAVAILABLE, res.Status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.discoverRejected.WithLabelValues("rate")))
+
+	// the buckets are per peer
+	res = svc.handleDiscover("p2", &libp2p_rppb.Message_Discover{Ns: "ns"})
+	assert.Equal(t, libp2p_rppb.Message_OK, res.Status)
+}
+
+func TestEmptyAddrPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+
+	unspecified := func([]ma.Multiaddr) []ma.Multiaddr {
+		return []ma.Multiaddr{ma.StringCast("/ip4/0.0.0.0/tcp/4040")}
+	}
+	rp := libp2p_rp.NewRendezvousPoint(client, server.ID(), libp2p_rp.ClientWithAddrsFactory(unspecified))
+
+	for _, tc := range []struct {
+		policy   emptyAddrPolicy
+		accepted bool
+	}{
+		{emptyAddrReject, false},
+		// the observed address is not public
+		{emptyAddrAugment, false},
+		{emptyAddrAccept, true},
+	} {
+		metrics := newRdvpMetrics()
+		_ = newService(server, db, serviceOptions{Metrics: metrics, EmptyAddrPolicy: tc.policy})
+
+		_, err := rp.Register(ctx, "ns", 60)
+		if tc.accepted {
+			assert.NoError(t, err, tc.policy)
+		} else {
+			assert.Error(t, err, tc.policy)
+			assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyEmptyAddrs))), tc.policy)
+		}
+	}
+}
+
+func TestNamespaceRejections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+
+	metrics := newRdvpMetrics()
+	_ = newService(server, db, serviceOptions{
+		Metrics:            metrics,
+		MaxNamespaceLength: 8,
+		NamespacePattern:   regexp.MustCompile(`^[a-z]+$`),
+	})
+	rp := libp2p_rp.NewRendezvousPoint(client, server.ID())
+
+	_, err = rp.Register(ctx, "valid", 60)
+	require.NoError(t, err)
+
+	_, err = rp.Register(ctx, "toolongnamespace", 60)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyNamespace))))
+
+	_, err = rp.Register(ctx, "Invalid", 60)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyNamespacePattern))))
+
+	// the discoveries are limited to the same length
+	_, _, err = rp.Discover(ctx, "toolongnamespace", 0, nil)
+	assert.Error(t, err)
+	regs, _, err := rp.Discover(ctx, "valid", 0, nil)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+}
+
+func TestAccessLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	_ = newService(server, db, serviceOptions{
+		Metrics:            newRdvpMetrics(),
+		AccessLogger:       zap.New(core),
+		NamespaceAllowlist: namespaceAllowlist{"ns"},
+	})
+
+	rp := libp2p_rp.NewRendezvousPoint(client, server.ID())
+	_, err = rp.Register(ctx, "ns", 60)
+	require.NoError(t, err)
+	_, _, err = rp.Discover(ctx, "ns", 0, nil)
+	require.NoError(t, err)
+	_, err = rp.Register(ctx, "other", 60)
+	require.Error(t, err)
+
+	entries := logs.All()
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "register", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{
+		"peer": client.ID().String(), "ns": "ns", "ttl": int64(60), "status": "OK", "outcome": "accepted",
+	}, entries[0].ContextMap())
+
+	assert.Equal(t, "discover", entries[1].Message)
+	assert.Equal(t, int64(1), entries[1].ContextMap()["results"])
+
+	assert.Equal(t, "register", entries[2].Message)
+	assert.Equal(t, "rejected", entries[2].ContextMap()["outcome"])
+	assert.Equal(t, "namespace not allowed", entries[2].ContextMap()["reason"])
+}
+
+func TestNewNamespaceRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "rdvp.db")
+	db, err := libp2p_rpdb.OpenDB(ctx, path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rawDB, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer rawDB.Close()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer server.Close()
+
+	metrics := newRdvpMetrics()
+	_ = newService(server, newCachedDB(metrics, db, 16, time.Minute), serviceOptions{
+		Metrics:             metrics,
+		NewNamespaceLimiter: newPeerRateLimiter(1, peerRateLimiterPeers),
+		NamespaceExists:     dbNamespaceExists(rawDB),
+	})
+
+	newClient := func() libp2p_rp.RendezvousPoint {
+		client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		require.NoError(t, client.Connect(ctx, libp2p_peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+		return libp2p_rp.NewRendezvousPoint(client, server.ID())
+	}
+	first, second := newClient(), newClient()
+
+	// one new namespace per minute
+	_, err = first.Register(ctx, "first", 60)
+	require.NoError(t, err)
+	_, err = first.Register(ctx, "other", 60)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyNewNamespaceRate))))
+
+	// caches an empty result for shared
+	regs, _, err := first.Discover(ctx, "shared", 0, nil)
+	require.NoError(t, err)
+	require.Empty(t, regs)
+
+	_, err = second.Register(ctx, "shared", 60)
+	require.NoError(t, err)
+
+	// shared exists now, despite the cached discovery
+	_, err = first.Register(ctx, "shared", 60)
+	assert.NoError(t, err)
+	_, err = first.Register(ctx, "first", 60)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.registrationRejected.WithLabelValues(string(policyNewNamespaceRate))))
+}
+
+func TestDiscoverChecks(t *testing.T) {
+	shards, err := parseShardMap(strings.NewReader("remote/* " + testShardOwner))
+	require.NoError(t, err)
+
+	newTestService := func() *service {
+		return &service{
+			logger:  zap.NewNop(),
+			metrics: newRdvpMetrics(),
+			db:      &failingDB{},
+			opts: serviceOptions{
+				MaxNamespaceLength: 12,
+				NamespacePattern:   regexp.MustCompile(`^[a-z/]+$`),
+				NamespaceAllowlist: namespaceAllowlist{"ns", "remote/*"},
+				ShardMap:           shards,
+			},
+		}
+	}
+	register := &libp2p_rppb.Message_PeerInfo{Addrs: [][]byte{ma.StringCast("/ip4/1.2.3.4/tcp/4040").Bytes()}}
+
+	for _, tc := range []struct {
+		ns     string
+		status libp2p_rppb.Message_ResponseStatus
+	}{
+		{"toolongnamespace", libp2p_rppb.Message_E_INVALID_NAMESPACE},
+		{"Invalid", libp2p_rppb.Message_E_INVALID_NAMESPACE},
+		{"other", libp2p_rppb.Message_E_NOT_AUTHORIZED},
+		{"remote/ns", libp2p_rppb.Message_E_UNAVAILABLE},
+	} {
+		svc := newTestService()
+
+		res := svc.handleRegister(remoteConn{remote: ma.StringCast("/ip4/1.2.3.4/tcp/4040")}, &libp2p_rppb.Message_Register{Ns: tc.ns, Peer: register})
+		assert.Equal(t, tc.status, res.Status, "register %s", tc.ns)
+		dres := svc.handleDiscover("p1", &libp2p_rppb.Message_Discover{Ns: tc.ns})
+		assert.Equal(t, tc.status, dres.Status, "discover %s", tc.ns)
+		sres := svc.handleDiscoverSubscribe("p1", &libp2p_rppb.Message_DiscoverSubscribe{Ns: tc.ns})
+		assert.Equal(t, tc.status, sres.Status, "subscribe %s", tc.ns)
+	}
+
+	// the rejections are accounted by operation
+	svc := newTestService()
+	svc.handleRegister(remoteConn{remote: ma.StringCast("/ip4/1.2.3.4/tcp/4040")}, &libp2p_rppb.Message_Register{Ns: "other", Peer: register})
+	svc.handleDiscoverSubscribe("p1", &libp2p_rppb.Message_DiscoverSubscribe{Ns: "other"})
+	svc.handleDiscover("p1", &libp2p_rppb.Message_Discover{Ns: "remote/ns"})
+	svc.handleDiscoverSubscribe("p1", &libp2p_rppb.Message_DiscoverSubscribe{Ns: "remote/ns"})
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.namespaceNotAllowed.WithLabelValues("register")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.namespaceNotAllowed.WithLabelValues("subscribe")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.shardReferrals.WithLabelValues("discover")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.shardReferrals.WithLabelValues("subscribe")))
+
+	// the discover queries and the subscriptions share the rate limit
+	svc = newTestService()
+	svc.opts.DiscoverLimiter = newPeerRateLimiter(1, peerRateLimiterPeers)
+	dres := svc.handleDiscover("p1", &libp2p_rppb.Message_Discover{Ns: "ns"})
+	assert.Equal(t, libp2p_rppb.Message_OK, dres.Status)
+	sres := svc.handleDiscoverSubscribe("p1", &libp2p_rppb.Message_DiscoverSubscribe{Ns: "ns"})
+	assert.Equal(t, libp2p_rppb.Message_E_UNAVAILABLE, sres.Status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.discoverRejected.WithLabelValues("rate")))
+}
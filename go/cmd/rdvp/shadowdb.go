@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// shadowDB serves everything from primary, and replays the operations on
+// shadow to compare the two backends: every write goes to both, a sampled
+// fraction of the reads is run on both and the results compared. The
+// shadow never changes what is served.
+//
+// Cookies are bound to the DB that made them, so only the discoveries
+// without cookie are compared. A shadow started empty reports mismatches
+// until the registrations made before it expire.
+type shadowDB struct {
+	libp2p_rpdbi.DB
+
+	logger     *zap.Logger
+	metrics    *rdvpMetrics
+	shadow     libp2p_rpdbi.DB
+	readSample float64
+}
+
+var _ libp2p_rpdbi.DB = (*shadowDB)(nil)
+
+func newShadowDB(logger *zap.Logger, metrics *rdvpMetrics, primary, shadow libp2p_rpdbi.DB, readSample float64) *shadowDB {
+	return &shadowDB{
+		DB:         primary,
+		logger:     logger,
+		metrics:    metrics,
+		shadow:     shadow,
+		readSample: readSample,
+	}
+}
+
+func (db *shadowDB) Close() error {
+	if err := db.shadow.Close(); err != nil {
+		db.logger.Warn("unable to close shadow db", zap.Error(err))
+	}
+	return db.DB.Close()
+}
+
+func (db *shadowDB) Register(p libp2p_peer.ID, ns string, addrs [][]byte, ttl int) (uint64, error) {
+	counter, err := db.DB.Register(p, ns, addrs, ttl)
+	if err != nil {
+		return counter, err
+	}
+
+	if _, err := db.shadow.Register(p, ns, addrs, ttl); err != nil {
+		db.shadowError("register", err)
+	}
+
+	return counter, nil
+}
+
+func (db *shadowDB) Unregister(p libp2p_peer.ID, ns string) error {
+	if err := db.DB.Unregister(p, ns); err != nil {
+		return err
+	}
+
+	if err := db.shadow.Unregister(p, ns); err != nil {
+		db.shadowError("unregister", err)
+	}
+
+	return nil
+}
+
+func (db *shadowDB) CountRegistrations(p libp2p_peer.ID) (int, error) {
+	count, err := db.DB.CountRegistrations(p)
+	if err != nil || !db.sampled() {
+		return count, err
+	}
+
+	shadowCount, err := db.shadow.CountRegistrations(p)
+	switch {
+	case err != nil:
+		db.shadowError("count", err)
+	case shadowCount != count:
+		db.mismatch("count", zap.Stringer("peer", p), zap.Int("primary", count), zap.Int("shadow", shadowCount))
+	}
+
+	return count, nil
+}
+
+func (db *shadowDB) Discover(ns string, cookie []byte, limit int) ([]libp2p_rpdbi.RegistrationRecord, []byte, error) {
+	regs, rcookie, err := db.DB.Discover(ns, cookie, limit)
+	if err != nil || cookie != nil || !db.sampled() {
+		return regs, rcookie, err
+	}
+
+	shadowRegs, _, err := db.shadow.Discover(ns, nil, limit)
+	switch {
+	case err != nil:
+		db.shadowError("discover", err)
+	case !sameRegistrations(regs, shadowRegs):
+		db.mismatch("discover", zap.String("ns", ns), zap.Int("primary", len(regs)), zap.Int("shadow", len(shadowRegs)))
+	}
+
+	return regs, rcookie, nil
+}
+
+func (db *shadowDB) sampled() bool {
+	return db.readSample > 0 && rand.Float64() < db.readSample // nolint:gosec
+}
+
+func (db *shadowDB) shadowError(op string, err error) {
+	db.metrics.shadowDBErrors.WithLabelValues(op).Inc()
+	db.logger.Warn("shadow db error", zap.String("op", op), zap.Error(err))
+}
+
+func (db *shadowDB) mismatch(op string, fields ...zap.Field) {
+	db.metrics.shadowDBMismatches.WithLabelValues(op).Inc()
+	db.logger.Warn("shadow db mismatch", append([]zap.Field{zap.String("op", op)}, fields...)...)
+}
+
+// sameRegistrations compares a and b regardless of their order, the TTLs
+// are ignored as they are computed at query time.
+func sameRegistrations(a, b []libp2p_rpdbi.RegistrationRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	key := func(reg libp2p_rpdbi.RegistrationRecord) string {
+		return string(reg.Id) + "\x00" + reg.Ns + "\x00" + string(bytes.Join(reg.Addrs, []byte{0}))
+	}
+
+	keys := func(regs []libp2p_rpdbi.RegistrationRecord) []string {
+		ks := make([]string, len(regs))
+		for i, reg := range regs {
+			ks[i] = key(reg)
+		}
+		sort.Strings(ks)
+		return ks
+	}
+
+	ka, kb := keys(a), keys(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	libp2p_ci "github.com/libp2p/go-libp2p/core/crypto"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestShadowDB(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer primary.Close()
+
+	shadow, err := libp2p_rpdb.OpenDB(ctx, memoryDBURN)
+	require.NoError(t, err)
+	defer shadow.Close()
+
+	metrics := newRdvpMetrics()
+	db := newShadowDB(zap.NewNop(), metrics, primary, shadow, 1)
+
+	priv, _, err := libp2p_ci.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+	p, err := libp2p_peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	addrs := [][]byte{ma.StringCast("/ip4/1.2.3.4/tcp/4040").Bytes()}
+	_, err = db.Register(p, "ns", addrs, 60)
+	require.NoError(t, err)
+
+	// both dbs got the write
+	regs, _, err := shadow.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+
+	regs, _, err = db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.shadowDBMismatches.WithLabelValues("discover")))
+
+	// diverge, the primary result is still served
+	require.NoError(t, shadow.Unregister(p, "ns"))
+
+	regs, _, err = db.Discover("ns", nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, regs, 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.shadowDBMismatches.WithLabelValues("discover")))
+
+	count, err := db.CountRegistrations(p)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.shadowDBMismatches.WithLabelValues("count")))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// shardReferralPrefix prefixes the status text of the responses referring
+// the client to the node owning the namespace, it is followed by the
+// multiaddr of that node.
+const shardReferralPrefix = "referral "
+
+// shardMap assigns the namespaces to the nodes of a sharded deployment.
+//
+// The file has one rule per line, `<glob pattern> <owner>`: the pattern is
+// matched against the namespace (see path.Match), the owner is either the
+// /p2p/ multiaddr of the owning node or `self`. The first matching rule
+// wins, the namespaces matching no rule are served by this node. Empty
+// lines and lines starting with `#` are ignored.
+type shardMap []shardRule
+
+type shardRule struct {
+	pattern string
+	// owner is nil when this node owns the namespaces
+	owner ma.Multiaddr
+}
+
+func readShardMap(filename string) (shardMap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := parseShardMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard map `%s`: %w", filename, err)
+	}
+	return m, nil
+}
+
+func parseShardMap(r io.Reader) (shardMap, error) {
+	var m shardMap
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected `<pattern> <owner>`", n)
+		}
+
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern `%s`: %w", n, fields[0], err)
+		}
+
+		rule := shardRule{pattern: fields[0]}
+		if fields[1] != "self" {
+			owner, err := ma.NewMultiaddr(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid owner `%s`: %w", n, fields[1], err)
+			}
+
+			if _, err := owner.ValueForProtocol(ma.P_P2P); err != nil {
+				return nil, fmt.Errorf("line %d: owner `%s` has no /p2p/ component", n, fields[1])
+			}
+
+			rule.owner = owner
+		}
+
+		m = append(m, rule)
+	}
+
+	return m, s.Err()
+}
+
+// referral returns the multiaddr of the node owning ns, or nil if it is
+// served by this node.
+func (m shardMap) referral(ns string) ma.Multiaddr {
+	for _, rule := range m {
+		if ok, _ := path.Match(rule.pattern, ns); ok {
+			return rule.owner
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	libp2p_rp "github.com/berty/go-libp2p-rendezvous"
+	libp2p_rppb "github.com/berty/go-libp2p-rendezvous/pb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testShardOwner = "/dns4/rdvp-b.example.com/tcp/4040/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+
+func TestParseShardMap(t *testing.T) {
+	m, err := parseShardMap(strings.NewReader(`
+# shard a
+berty/a-* self
+berty/*   ` + testShardOwner + `
+`))
+	require.NoError(t, err)
+
+	assert.Nil(t, m.referral("berty/a-1"))
+	assert.Nil(t, m.referral("other"))
+	if owner := m.referral("berty/b-1"); assert.NotNil(t, owner) {
+		assert.Equal(t, testShardOwner, owner.String())
+	}
+
+	for _, invalid := range []string{
+		"berty/*",
+		"[ self",
+		"berty/* /ip4/1.2.3.4/tcp/4040",
+		"berty/* not-a-multiaddr",
+	} {
+		_, err := parseShardMap(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestShardReferral(t *testing.T) {
+	m, err := parseShardMap(strings.NewReader("remote/* " + testShardOwner))
+	require.NoError(t, err)
+
+	metrics := newRdvpMetrics()
+	svc := &service{
+		logger:  zap.NewNop(),
+		metrics: metrics,
+		opts:    serviceOptions{ShardMap: m, MaxNamespaceLength: libp2p_rp.MaxNamespaceLength},
+	}
+
+	res := svc.handleDiscover("", &libp2p_rppb.Message_Discover{Ns: "remote/ns"})
+	assert.Equal(t, libp2p_rppb.Message_E_UNAVAILABLE, res.Status)
+	assert.Equal(t, shardReferralPrefix+testShardOwner, res.StatusText)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.shardReferrals.WithLabelValues("discover")))
+}
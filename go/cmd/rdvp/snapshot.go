@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// snapshotRegistration is a registration, as stored in a JSON snapshot of
+// the rendezvous DB (an array of snapshotRegistration).
+type snapshotRegistration struct {
+	Peer      string   `json:"peer"`
+	Namespace string   `json:"ns"`
+	Addrs     []string `json:"addrs"`
+	// Expire is the expiration time of the registration, in unix seconds.
+	Expire int64 `json:"expire"`
+}
+
+func (r snapshotRegistration) key() registrationSnapshotKey {
+	return registrationSnapshotKey{Peer: r.Peer, Namespace: r.Namespace}
+}
+
+type registrationSnapshotKey struct {
+	Peer      string `json:"peer"`
+	Namespace string `json:"ns"`
+}
+
+func readSnapshot(path string) ([]snapshotRegistration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var regs []snapshotRegistration
+	if err := json.Unmarshal(data, &regs); err != nil {
+		return nil, fmt.Errorf("invalid snapshot `%s`: %w", path, err)
+	}
+
+	return regs, nil
+}
+
+type snapshotChange struct {
+	registrationSnapshotKey
+
+	// ExpireDelta is the expiration of b minus the expiration of a, in
+	// seconds.
+	ExpireDelta int64    `json:"expire_delta,omitempty"`
+	AddrsOnlyA  []string `json:"addrs_only_a,omitempty"`
+	AddrsOnlyB  []string `json:"addrs_only_b,omitempty"`
+}
+
+type snapshotDiff struct {
+	OnlyA   []registrationSnapshotKey `json:"only_a"`
+	OnlyB   []registrationSnapshotKey `json:"only_b"`
+	Changed []snapshotChange          `json:"changed"`
+}
+
+func (d snapshotDiff) empty() bool {
+	return len(d.OnlyA) == 0 && len(d.OnlyB) == 0 && len(d.Changed) == 0
+}
+
+// diffSnapshots compares two snapshots, registrations are matched by peer
+// and namespace. Results are ordered by namespace then peer.
+func diffSnapshots(a, b []snapshotRegistration) snapshotDiff {
+	d := snapshotDiff{
+		OnlyA:   []registrationSnapshotKey{},
+		OnlyB:   []registrationSnapshotKey{},
+		Changed: []snapshotChange{},
+	}
+
+	inB := make(map[registrationSnapshotKey]snapshotRegistration, len(b))
+	for _, reg := range b {
+		inB[reg.key()] = reg
+	}
+
+	inA := make(map[registrationSnapshotKey]bool, len(a))
+	for _, ra := range a {
+		key := ra.key()
+		inA[key] = true
+
+		rb, ok := inB[key]
+		if !ok {
+			d.OnlyA = append(d.OnlyA, key)
+			continue
+		}
+
+		change := snapshotChange{
+			registrationSnapshotKey: key,
+			ExpireDelta:             rb.Expire - ra.Expire,
+			AddrsOnlyA:              stringsMissing(ra.Addrs, rb.Addrs),
+			AddrsOnlyB:              stringsMissing(rb.Addrs, ra.Addrs),
+		}
+		if change.ExpireDelta != 0 || len(change.AddrsOnlyA) > 0 || len(change.AddrsOnlyB) > 0 {
+			d.Changed = append(d.Changed, change)
+		}
+	}
+
+	for _, rb := range b {
+		if !inA[rb.key()] {
+			d.OnlyB = append(d.OnlyB, rb.key())
+		}
+	}
+
+	sortKeys := func(keys []registrationSnapshotKey) {
+		sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	}
+	sortKeys(d.OnlyA)
+	sortKeys(d.OnlyB)
+	sort.Slice(d.Changed, func(i, j int) bool {
+		return keyLess(d.Changed[i].registrationSnapshotKey, d.Changed[j].registrationSnapshotKey)
+	})
+
+	return d
+}
+
+// printSnapshotDiff writes d in a human readable form, one line per
+// registration.
+func printSnapshotDiff(w io.Writer, d snapshotDiff) {
+	for _, key := range d.OnlyA {
+		fmt.Fprintf(w, "- %s %s\n", key.Namespace, key.Peer)
+	}
+	for _, key := range d.OnlyB {
+		fmt.Fprintf(w, "+ %s %s\n", key.Namespace, key.Peer)
+	}
+	for _, c := range d.Changed {
+		var details []string
+		if c.ExpireDelta != 0 {
+			details = append(details, fmt.Sprintf("expire %+ds", c.ExpireDelta))
+		}
+		for _, addr := range c.AddrsOnlyA {
+			details = append(details, "-"+addr)
+		}
+		for _, addr := range c.AddrsOnlyB {
+			details = append(details, "+"+addr)
+		}
+		fmt.Fprintf(w, "~ %s %s: %s\n", c.Namespace, c.Peer, strings.Join(details, " "))
+	}
+}
+
+func keyLess(a, b registrationSnapshotKey) bool {
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Peer < b.Peer
+}
+
+// stringsMissing returns the elements of a that are not in b.
+func stringsMissing(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	var missing []string
+	for _, s := range a {
+		if !inB[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	a := []snapshotRegistration{
+		{Peer: "p1", Namespace: "ns", Addrs: []string{"/ip4/1.2.3.4/tcp/1"}, Expire: 100},
+		{Peer: "p2", Namespace: "ns", Addrs: []string{"/ip4/1.2.3.4/tcp/2"}, Expire: 100},
+		{Peer: "p3", Namespace: "ns", Addrs: []string{"/ip4/1.2.3.4/tcp/3"}, Expire: 100},
+		{Peer: "p1", Namespace: "other", Addrs: []string{"/ip4/1.2.3.4/tcp/1"}, Expire: 100},
+	}
+	b := []snapshotRegistration{
+		{Peer: "p1", Namespace: "ns", Addrs: []string{"/ip4/1.2.3.4/tcp/1"}, Expire: 100},
+		{Peer: "p2", Namespace: "ns", Addrs: []string{"/ip4/1.2.3.4/tcp/2"}, Expire: 160},
+		{Peer: "p3", Namespace: "ns", Addrs: []string{"/ip4/5.6.7.8/tcp/3"}, Expire: 100},
+		{Peer: "p4", Namespace: "ns", Addrs: []string{"/ip4/1.2.3.4/tcp/4"}, Expire: 100},
+	}
+
+	d := diffSnapshots(a, b)
+	assert.Equal(t, []registrationSnapshotKey{{Peer: "p1", Namespace: "other"}}, d.OnlyA)
+	assert.Equal(t, []registrationSnapshotKey{{Peer: "p4", Namespace: "ns"}}, d.OnlyB)
+	assert.Equal(t, []snapshotChange{
+		{registrationSnapshotKey: registrationSnapshotKey{Peer: "p2", Namespace: "ns"}, ExpireDelta: 60},
+		{
+			registrationSnapshotKey: registrationSnapshotKey{Peer: "p3", Namespace: "ns"},
+			AddrsOnlyA:              []string{"/ip4/1.2.3.4/tcp/3"},
+			AddrsOnlyB:              []string{"/ip4/5.6.7.8/tcp/3"},
+		},
+	}, d.Changed)
+	assert.False(t, d.empty())
+
+	assert.True(t, diffSnapshots(a, a).empty())
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startupJitter sleeps a random duration in [0, max) so the nodes of a fleet
+// restarted at once don't reconnect to their dependencies all together.
+func startupJitter(ctx context.Context, logger *zap.Logger, max time.Duration) error {
+	if max <= 0 {
+		return nil
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(max))) // nolint:gosec
+	logger.Info("startup jitter, delaying the network initialization", zap.Duration("jitter", jitter), zap.Duration("max", max))
+
+	timer := time.NewTimer(jitter)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestStartupJitter(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+
+	require.NoError(t, startupJitter(context.Background(), logger, 0))
+	require.Equal(t, 0, logs.Len())
+
+	start := time.Now()
+	require.NoError(t, startupJitter(context.Background(), logger, 50*time.Millisecond))
+	require.Less(t, time.Since(start), time.Second)
+
+	entries := logs.FilterMessageSnippet("startup jitter").All()
+	require.Len(t, entries, 1)
+	jitter := entries[0].ContextMap()["jitter"].(time.Duration)
+	require.GreaterOrEqual(t, jitter, time.Duration(0))
+	require.Less(t, jitter, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, startupJitter(ctx, logger, time.Hour), context.Canceled)
+}
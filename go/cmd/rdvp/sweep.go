@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// expireBatchPause is the pause between two batches, so the registrations
+// can take the write lock in between.
+const expireBatchPause = 10 * time.Millisecond
+
+// expireSweeper deletes the expired registrations, more often than the
+// rendezvous DB (every 15min) so the DB file doesn't grow with expired rows
+// on busy nodes. If batchSize is set, the rows are deleted in batches of at
+// most batchSize, instead of a single DELETE which holds the write lock for
+// the whole sweep on large stores.
+type expireSweeper struct {
+	logger    *zap.Logger
+	metrics   *rdvpMetrics
+	db        *sql.DB
+	batchSize int
+
+	// total is the number of rows deleted since the sweeper started.
+	total int64
+}
+
+func newExpireSweeper(logger *zap.Logger, metrics *rdvpMetrics, db *sql.DB, batchSize int) *expireSweeper {
+	return &expireSweeper{
+		logger:    logger,
+		metrics:   metrics,
+		db:        db,
+		batchSize: batchSize,
+	}
+}
+
+// run sweeps every interval until ctx is done.
+func (s *expireSweeper) run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		start := time.Now()
+		deleted, err := s.sweep(ctx)
+		s.metrics.expireSweepDuration.Observe(time.Since(start).Seconds())
+		s.metrics.expireSweepDeleted.Add(float64(deleted))
+		s.total += deleted
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			s.logger.Warn("expiry sweep failed", zap.Int64("deleted", deleted), zap.Error(err))
+			continue
+		}
+
+		s.logger.Debug("expiry sweep", zap.Int64("deleted", deleted), zap.Duration("duration", time.Since(start)))
+		if deleted > 0 {
+			s.logger.Info("expired registrations deleted", zap.Int64("total", s.total))
+		}
+	}
+}
+
+// sweep deletes the registrations expired at the time of the call, it
+// returns the number of rows deleted.
+func (s *expireSweeper) sweep(ctx context.Context) (int64, error) {
+	now := time.Now().Unix()
+
+	if s.batchSize <= 0 {
+		res, err := s.db.ExecContext(ctx, "DELETE FROM Registrations WHERE expire < ?", now)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+
+	var total int64
+	for {
+		res, err := s.db.ExecContext(ctx,
+			"DELETE FROM Registrations WHERE counter IN (SELECT counter FROM Registrations WHERE expire < ? LIMIT ?)",
+			now, s.batchSize)
+		if err != nil {
+			return total, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if n < int64(s.batchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-time.After(expireBatchPause):
+		case <-ctx.Done():
+			return total, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	libp2p_rpdb "github.com/berty/go-libp2p-rendezvous/db/sqlcipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestExpireSweeper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the rendezvous db only creates the schema, it is closed so its own
+	// expiry cleanup doesn't race with the sweeper
+	path := filepath.Join(t.TempDir(), "rdvp.db")
+	rdb, err := libp2p_rpdb.OpenDB(ctx, path)
+	require.NoError(t, err)
+	require.NoError(t, rdb.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	insert := func(n int, expire int64) {
+		for i := 0; i < n; i++ {
+			_, err := db.ExecContext(ctx, "INSERT INTO Registrations (peer, ns, expire, addrs) VALUES (?, ?, ?, ?)",
+				fmt.Sprintf("peer-%d-%d", expire, i), "ns", expire, []byte{})
+			require.NoError(t, err)
+		}
+	}
+
+	count := func() (n int) {
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Registrations").Scan(&n))
+		return n
+	}
+
+	for _, batchSize := range []int{0, 2} {
+		sweeper := newExpireSweeper(zap.NewNop(), newRdvpMetrics(), db, batchSize)
+
+		insert(5, 1)
+		insert(2, time.Now().Unix()+60)
+
+		deleted, err := sweeper.sweep(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), deleted, "batch size %d", batchSize)
+		assert.Equal(t, 2, count())
+
+		_, err = db.ExecContext(ctx, "DELETE FROM Registrations")
+		require.NoError(t, err)
+	}
+}
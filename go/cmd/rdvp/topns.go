@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type namespaceCount struct {
+	Namespace string `json:"namespace"`
+	Count     int    `json:"count"`
+}
+
+// topNamespacesFunc returns the n namespaces with the most active
+// registrations, by decreasing count.
+type topNamespacesFunc func(ctx context.Context, n int) ([]namespaceCount, error)
+
+// dbTopNamespaces aggregates the active registrations stored in db, the
+// short-lived health check registrations are left out like in the index.
+func dbTopNamespaces(db *sql.DB) topNamespacesFunc {
+	return func(ctx context.Context, n int) ([]namespaceCount, error) {
+		rows, err := db.QueryContext(ctx,
+			"SELECT ns, COUNT(*) AS c FROM Registrations WHERE expire > ? AND ns != ? GROUP BY ns ORDER BY c DESC, ns LIMIT ?",
+			time.Now().Unix(), healthCheckNamespace, n)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var top []namespaceCount
+		for rows.Next() {
+			var nc namespaceCount
+			if err := rows.Scan(&nc.Namespace, &nc.Count); err != nil {
+				return nil, err
+			}
+			top = append(top, nc)
+		}
+
+		return top, rows.Err()
+	}
+}
+
+// countRegistrationsFunc returns the number of active registrations.
+type countRegistrationsFunc func(ctx context.Context) (int, error)
+
+// dbCountRegistrations counts the active registrations stored in db, except
+// the health check ones.
+func dbCountRegistrations(db *sql.DB) countRegistrationsFunc {
+	return func(ctx context.Context) (int, error) {
+		var count int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Registrations WHERE expire > ? AND ns != ?", time.Now().Unix(), healthCheckNamespace).Scan(&count)
+		return count, err
+	}
+}
+
+// namespaceExistsFunc reports whether ns has active registrations.
+type namespaceExistsFunc func(ctx context.Context, ns string) (bool, error)
+
+// dbNamespaceExists queries db directly, bypassing the discovery cache and
+// the other layers of the service db.
+func dbNamespaceExists(db *sql.DB) namespaceExistsFunc {
+	return func(ctx context.Context, ns string) (bool, error) {
+		var exists bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM Registrations WHERE ns = ? AND expire > ?)", ns, time.Now().Unix()).Scan(&exists)
+		return exists, err
+	}
+}
+
+// namespaceExists looks for an active registration on ns in the index, it is
+// used when the DB cannot be queried directly (ie. in-memory DB).
+func (idx *registrationIndex) namespaceExists(_ context.Context, ns string) (bool, error) {
+	now := time.Now()
+
+	idx.muRegs.Lock()
+	defer idx.muRegs.Unlock()
+
+	for key, info := range idx.regs {
+		if key.ns == ns && info.expireAt.After(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// count counts the active registrations of the index, it is used when the
+// DB cannot be queried directly (ie. in-memory DB).
+func (idx *registrationIndex) count(_ context.Context) (int, error) {
+	now := time.Now()
+
+	idx.muRegs.Lock()
+	defer idx.muRegs.Unlock()
+
+	idx.pruneLocked(now)
+	return len(idx.regs), nil
+}
+
+// topNamespaces aggregates the active registrations of the index, it is used
+// when the DB cannot be queried directly (ie. in-memory DB).
+func (idx *registrationIndex) topNamespaces(_ context.Context, n int) ([]namespaceCount, error) {
+	now := time.Now()
+	counts := make(map[string]int)
+
+	idx.muRegs.Lock()
+	idx.pruneLocked(now)
+	for key := range idx.regs {
+		counts[key.ns]++
+	}
+	idx.muRegs.Unlock()
+
+	top := make([]namespaceCount, 0, len(counts))
+	for ns, count := range counts {
+		top = append(top, namespaceCount{Namespace: ns, Count: count})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Namespace < top[j].Namespace
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top, nil
+}
+
+// logTopNamespaces logs the n namespaces with the most active registrations
+// every interval.
+func logTopNamespaces(ctx context.Context, logger *zap.Logger, top topNamespacesFunc, n int, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		namespaces, err := top(ctx, n)
+		if err != nil {
+			logger.Warn("unable to aggregate registrations by namespace", zap.Error(err))
+			continue
+		}
+
+		logger.Info("top namespaces by active registrations", zap.Any("namespaces", namespaces))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync/atomic"
+
+	libp2p_network "github.com/libp2p/go-libp2p/core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	libp2p_relayproto "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// transport labels of the bandwidth accounting, the set is closed to bound
+// the cardinality of rdvp_transport_bytes_total.
+const (
+	transportTCP          = "tcp"
+	transportQUIC         = "quic"
+	transportWebsocket    = "websocket"
+	transportWebTransport = "webtransport"
+	// connections to peers reached through a relay
+	transportCircuit = "circuit"
+	// streams of the relay service (hop and stop), whatever the transport
+	transportRelay = "relay"
+	// the connection is gone or its transport is not one of the above
+	transportUnknown = "unknown"
+)
+
+var bandwidthTransports = []string{
+	transportTCP, transportQUIC, transportWebsocket, transportWebTransport,
+	transportCircuit, transportRelay, transportUnknown,
+}
+
+// transportOf returns the transport label of a connection remote address.
+func transportOf(remote ma.Multiaddr) string {
+	if _, ok := hasProtocol(remote, ma.P_CIRCUIT); ok {
+		return transportCircuit
+	}
+	if _, ok := hasProtocol(remote, ma.P_WEBTRANSPORT); ok {
+		return transportWebTransport
+	}
+	if _, ok := hasProtocol(remote, ma.P_WS); ok {
+		return transportWebsocket
+	}
+	if _, ok := hasProtocol(remote, ma.P_WSS); ok {
+		return transportWebsocket
+	}
+	if _, ok := hasProtocol(remote, ma.P_QUIC_V1); ok {
+		return transportQUIC
+	}
+	if _, ok := hasProtocol(remote, ma.P_QUIC); ok {
+		return transportQUIC
+	}
+	if _, ok := hasProtocol(remote, ma.P_TCP); ok {
+		return transportTCP
+	}
+	return transportUnknown
+}
+
+type transportBytes struct {
+	in, out atomic.Int64
+}
+
+// transportBandwidth accounts the stream traffic by transport. The
+// reporter only gets the peer of a stream, the transport is the one of the
+// first connection to this peer: with several connections to a peer over
+// different transports the traffic is attributed to one of them.
+type transportBandwidth struct {
+	// network must be set before the host opens any connection
+	network libp2p_network.Network
+
+	// built once, only the counters are updated
+	transports map[string]*transportBytes
+}
+
+func newTransportBandwidth() *transportBandwidth {
+	t := &transportBandwidth{transports: make(map[string]*transportBytes, len(bandwidthTransports))}
+	for _, name := range bandwidthTransports {
+		t.transports[name] = &transportBytes{}
+	}
+	return t
+}
+
+func (t *transportBandwidth) transport(proto protocol.ID, p libp2p_peer.ID) *transportBytes {
+	if proto == libp2p_relayproto.ProtoIDv2Hop || proto == libp2p_relayproto.ProtoIDv2Stop {
+		return t.transports[transportRelay]
+	}
+
+	if t.network != nil {
+		if conns := t.network.ConnsToPeer(p); len(conns) > 0 {
+			return t.transports[transportOf(conns[0].RemoteMultiaddr())]
+		}
+	}
+	return t.transports[transportUnknown]
+}
+
+// totals returns the bytes received and sent by transport since the start.
+func (t *transportBandwidth) totals() map[string]bandwidthStats {
+	totals := make(map[string]bandwidthStats, len(t.transports))
+	for name, tb := range t.transports {
+		totals[name] = bandwidthStats{In: tb.in.Load(), Out: tb.out.Load()}
+	}
+	return totals
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"go.uber.org/zap"
+)
+
+// runSystemdWatchdog notifies systemd that the node is ready, then, if the
+// unit has a WatchdogSec, sends a heartbeat every half watchdog interval as
+// long as check succeeds, so systemd restarts an unhealthy node. It does
+// nothing when not run under systemd. It returns when ctx is done.
+func runSystemdWatchdog(ctx context.Context, logger *zap.Logger, check func() error) error {
+	ok, err := daemon.SdNotify(false, daemon.SdNotifyReady)
+	if err != nil {
+		logger.Warn("unable to notify systemd", zap.Error(err))
+	}
+	if !ok {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	defer daemon.SdNotify(false, daemon.SdNotifyStopping) // nolint:errcheck
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logger.Warn("invalid systemd watchdog settings", zap.Error(err))
+	}
+	if interval <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	logger.Info("systemd watchdog enabled", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		if err := check(); err != nil {
+			if healthy {
+				logger.Error("node unhealthy, stopping the systemd watchdog heartbeats", zap.Error(err))
+			}
+			healthy = false
+		} else {
+			if !healthy {
+				logger.Info("node healthy again, resuming the systemd watchdog heartbeats")
+			}
+			healthy = true
+			if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+				logger.Warn("unable to notify systemd", zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRunSystemdWatchdog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// not under systemd
+	t.Setenv("NOTIFY_SOCKET", "")
+	canceled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	assert.ErrorIs(t, runSystemdWatchdog(canceled, zap.NewNop(), func() error { return nil }), context.Canceled)
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "20000")
+
+	var unhealthy atomic.Bool
+	done := make(chan error)
+	go func() {
+		done <- runSystemdWatchdog(ctx, zap.NewNop(), func() error {
+			if unhealthy.Load() {
+				return errors.New("unhealthy")
+			}
+			return nil
+		})
+	}()
+
+	read := func() string {
+		buf := make([]byte, 64)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	assert.Equal(t, "READY=1", read())
+	assert.Equal(t, "WATCHDOG=1", read())
+	assert.Equal(t, "WATCHDOG=1", read())
+
+	// no more heartbeats once unhealthy, read() times out
+	unhealthy.Store(true)
+	for read() == "WATCHDOG=1" {
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, "STOPPING=1", read())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// writeQueuePerSlot is the number of writes that can wait for each of the
+// concurrent write slots of a writeLimitedDB.
+const writeQueuePerSlot = 4
+
+// writeQueueTimeout is how long a write waits for a slot before being
+// rejected with errWriteQueueFull.
+const writeQueueTimeout = 2 * time.Second
+
+var errWriteQueueFull = errors.New("too many concurrent writes")
+
+// writeLimitedDB bounds the registrations and unregistrations running
+// concurrently on the wrapped DB. The writes exceeding the limit wait for
+// a slot, unless too many are already waiting or no slot is released in
+// time, then they fail with errWriteQueueFull. The reads are not limited.
+type writeLimitedDB struct {
+	libp2p_rpdbi.DB
+
+	metrics   *rdvpMetrics
+	slots     chan struct{}
+	queued    atomic.Int64
+	maxQueued int64
+	timeout   time.Duration
+}
+
+var _ libp2p_rpdbi.DB = (*writeLimitedDB)(nil)
+
+func newWriteLimitedDB(metrics *rdvpMetrics, db libp2p_rpdbi.DB, maxConcurrent int) *writeLimitedDB {
+	return &writeLimitedDB{
+		DB:        db,
+		metrics:   metrics,
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: int64(maxConcurrent * writeQueuePerSlot),
+		timeout:   writeQueueTimeout,
+	}
+}
+
+func (db *writeLimitedDB) Register(p libp2p_peer.ID, ns string, addrs [][]byte, ttl int) (uint64, error) {
+	if err := db.acquire(); err != nil {
+		return 0, err
+	}
+	defer db.release()
+
+	return db.DB.Register(p, ns, addrs, ttl)
+}
+
+func (db *writeLimitedDB) Unregister(p libp2p_peer.ID, ns string) error {
+	if err := db.acquire(); err != nil {
+		return err
+	}
+	defer db.release()
+
+	return db.DB.Unregister(p, ns)
+}
+
+func (db *writeLimitedDB) acquire() error {
+	select {
+	case db.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if db.queued.Add(1) > db.maxQueued {
+		db.queued.Add(-1)
+		db.metrics.dbWritesRejected.Inc()
+		return errWriteQueueFull
+	}
+
+	db.metrics.dbWriteQueue.Inc()
+	defer func() {
+		db.metrics.dbWriteQueue.Dec()
+		db.queued.Add(-1)
+	}()
+
+	timer := time.NewTimer(db.timeout)
+	defer timer.Stop()
+
+	select {
+	case db.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		db.metrics.dbWritesRejected.Inc()
+		return errWriteQueueFull
+	}
+}
+
+func (db *writeLimitedDB) release() {
+	<-db.slots
+}
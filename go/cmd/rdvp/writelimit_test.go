@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	libp2p_rpdbi "github.com/berty/go-libp2p-rendezvous/db"
+	libp2p_peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingDB blocks the registrations until unblock is closed.
+type blockingDB struct {
+	libp2p_rpdbi.DB
+	unblock chan struct{}
+}
+
+func (db *blockingDB) Register(libp2p_peer.ID, string, [][]byte, int) (uint64, error) {
+	<-db.unblock
+	return 0, nil
+}
+
+func TestWriteLimitedDB(t *testing.T) {
+	metrics := newRdvpMetrics()
+	blocking := &blockingDB{unblock: make(chan struct{})}
+	db := newWriteLimitedDB(metrics, blocking, 1)
+
+	// 1 write running and writeQueuePerSlot waiting
+	var wg sync.WaitGroup
+	for i := 0; i < 1+writeQueuePerSlot; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Register("p", "ns", nil, 60)
+			assert.NoError(t, err)
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.dbWriteQueue) == writeQueuePerSlot
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := db.Register("p", "ns", nil, 60)
+	assert.ErrorIs(t, err, errWriteQueueFull)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.dbWritesRejected))
+
+	close(blocking.unblock)
+	wg.Wait()
+	assert.Zero(t, testutil.ToFloat64(metrics.dbWriteQueue))
+}
+
+func TestWriteLimitedDBTimeout(t *testing.T) {
+	metrics := newRdvpMetrics()
+	blocking := &blockingDB{unblock: make(chan struct{})}
+	db := newWriteLimitedDB(metrics, blocking, 1)
+	db.timeout = 50 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := db.Register("p", "ns", nil, 60)
+		assert.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return len(db.slots) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// queued, then rejected once the timeout expires
+	_, err := db.Register("p", "ns", nil, 60)
+	assert.ErrorIs(t, err, errWriteQueueFull)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.dbWritesRejected))
+	assert.Zero(t, testutil.ToFloat64(metrics.dbWriteQueue))
+
+	close(blocking.unblock)
+	<-done
+}